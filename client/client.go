@@ -12,6 +12,7 @@ import (
 	"encoding/json"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"sort"
@@ -42,13 +43,32 @@ type Router struct {
 	// Addrs of the host that code will be running.
 	Addrs string
 
+	// TTL is the lease of the routes in the router server. The client renews
+	// the lease before it expires. If zero DefaultTTL is used.
+	TTL time.Duration
+
+	// Weight of this host in the routes. Zero is the same as one. Use
+	// SetWeight to change it after the routes are registered.
+	Weight int
+
+	// Tag of this host in the routes, like its version. See SetSplit.
+	Tag string
+
+	// Token is the bearer token sent to the router server, if it requires
+	// one. For the client certificates see ConfigHTTPClient.
+	Token string
+
 	router *httprouter.Router
 
 	routes map[*router.Route]http.HandlerFunc
 	lck    sync.Mutex
 	once   sync.Once
+	stop   chan struct{}
 }
 
+// DefaultTTL is the lease used when Router.TTL is zero.
+var DefaultTTL = time.Minute
+
 //HTTPClient is the http.Client
 var HTTPClient *http.Client
 
@@ -97,7 +117,8 @@ func ConfigHTTPClient(certificate, privateKey, ca string, insecure bool) error {
 }
 
 // Start initialize the router. Setup the server that will receive the income
-// requests and send it to the right route.
+// requests and send it to the right route. The leases of the routes are renewed
+// until ctx is canceled or Close is called.
 func (r *Router) Start(ctx context.Context) error {
 	r.once.Do(func() {
 		r.router = httprouter.New()
@@ -108,18 +129,17 @@ func (r *Router) Start(ctx context.Context) error {
 			"en": struct{}{},
 		}
 		r.routes = make(map[*router.Route]http.HandlerFunc)
+		r.stop = make(chan struct{})
+		stop := r.stop
 		go func() {
 			for {
 				select {
-				case <-time.After(time.Minute):
-					r.lck.Lock()
-					for route, handler := range r.routes {
-						err := r.handlerfunc(ctx, route, handler)
-						if err == nil {
-							log.Errorf("Route re add to proxy: %v %v %v", route.Router, route.Methode, route.Path)
-						}
-					}
-					r.lck.Unlock()
+				case <-ctx.Done():
+					return
+				case <-stop:
+					return
+				case <-time.After(r.ttl() / 3):
+					r.renewAll(ctx)
 				}
 			}
 		}()
@@ -127,6 +147,44 @@ func (r *Router) Start(ctx context.Context) error {
 	return nil
 }
 
+// renewAll renews the leases of the routes. The lock isn't held while the
+// router server is called, so a slow router doesn't block the other methods.
+func (r *Router) renewAll(ctx context.Context) {
+	r.lck.Lock()
+	routes := make(map[*router.Route]router.Route, len(r.routes))
+	for route := range r.routes {
+		routes[route] = *route
+	}
+	r.lck.Unlock()
+	for key, route := range routes {
+		err := r.renew(ctx, &route)
+		if err == nil {
+			continue
+		}
+		// The lease expired or the router lost the route, add it again.
+		err = r.register(ctx, &route)
+		if err != nil {
+			continue
+		}
+		r.lck.Lock()
+		_, found := r.routes[key]
+		r.lck.Unlock()
+		if !found {
+			// Removed while it was added again.
+			r.delRoute(ctx, &route)
+			continue
+		}
+		log.DebugLevel().Printf("Route re add to proxy: %v %v %v", route.Router, route.Methode, route.Path)
+	}
+}
+
+func (r *Router) ttl() time.Duration {
+	if r.TTL <= 0 {
+		return DefaultTTL
+	}
+	return r.TTL
+}
+
 // ServeHTTP is a http server with the route setup by *Router.
 func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	r.router.ServeHTTP(rw, req)
@@ -172,15 +230,29 @@ var BodyLimitSize int64 = 1048576
 
 // HandlerFunc is a generic method to add a route into the router.
 func (r *Router) HandlerFunc(ctx context.Context, method, path string, handler http.HandlerFunc) error {
+	return r.HandleRoute(ctx, &router.Route{
+		Methode: method,
+		Path:    path,
+	}, handler)
+}
+
+// HandleRoute adds a route into the router with the proxy options of route,
+// like the timeout and the retry policy. Router, RedirTo, TTL and Weight are
+// set by the client, and Tag if route has none.
+func (r *Router) HandleRoute(ctx context.Context, route *router.Route, handler http.HandlerFunc) error {
 	r.lck.Lock()
 	defer r.lck.Unlock()
 
-	route := &router.Route{
-		Methode: method,
-		Router:  r.Router,
-		Path:    path,
-		RedirTo: r.Addrs,
+	rt := *route
+	route = &rt
+	route.Router = r.Router
+	route.RedirTo = r.Addrs
+	route.TTL = r.ttl()
+	route.Weight = r.Weight
+	if route.Tag == "" {
+		route.Tag = r.Tag
 	}
+	route.Backends = nil
 
 	err := r.handlerfunc(ctx, route, handler)
 	if err != nil {
@@ -193,27 +265,138 @@ func (r *Router) HandlerFunc(ctx context.Context, method, path string, handler h
 }
 
 func (r *Router) handlerfunc(ctx context.Context, route *router.Route, handler http.HandlerFunc) (err error) {
-	var body []byte
-
 	defer func() {
 		if err != nil {
 			log.Errorf("Can't add handler (%v, %v, %v) error: %v", route.Router, route.Methode, route.Path, err)
 		}
 	}()
 
-	buf, err := json.Marshal(route)
+	err = r.register(ctx, route)
 	if err != nil {
-		err = e.Forward(err)
 		return
 	}
+
+	defer func() {
+		r := recover()
+		switch x := r.(type) {
+		case error:
+			err = x
+		case string:
+			err = e.New(x)
+		default:
+			if x != nil {
+				err = e.New(x)
+			}
+		}
+	}()
+	r.router.Handle(route.Methode, route.Path, handler)
+	return
+}
+
+// credentials adds the token to the request to the router server.
+func (r *Router) credentials(req *http.Request) {
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+}
+
+// routesPath is the path of the routes of the router in the control API.
+func (r *Router) routesPath() string {
+	return "/routers/" + url.PathEscape(r.Router) + "/routes"
+}
+
+func routeQuery(route *router.Route) url.Values {
+	return url.Values{
+		"method": {route.Methode},
+		"path":   {route.Path},
+	}
+}
+
+func backendQuery(route *router.Route) url.Values {
+	q := routeQuery(route)
+	q.Set("addr", route.RedirTo)
+	return q
+}
+
+// newRequest creates a request to the path of the control API of the router
+// server with in, if not nil, in the body.
+func (r *Router) newRequest(ctx context.Context, method, path string, query url.Values, in interface{}) (*http.Request, error) {
+	var body io.Reader
+	if in != nil {
+		buf, err := json.Marshal(in)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		body = bytes.NewReader(buf)
+	}
 	u := neturl.Copy(r.URL)
-	u.Path = "/en/_router/add"
+	u.Path = router.APIPrefix + path
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, e.New(err)
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	r.credentials(req)
+	return req, nil
+}
 
+// result reads the response of the router server in out, if not nil. The
+// errors sent by the server are *router.Problem, the others say what failed.
+func result(resp *http.Response, out interface{}, what string) error {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, BodyLimitSize))
+	if err != nil {
+		return e.Forward(err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if out == nil || len(body) == 0 {
+			return nil
+		}
+		err = json.Unmarshal(body, out)
+		if err != nil {
+			return e.Forward(err)
+		}
+		return nil
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt == router.ProblemContentType {
+		problem := &router.Problem{}
+		err = json.Unmarshal(body, problem)
+		if err != nil {
+			return e.Forward(err)
+		}
+		return problem
+	}
+	return e.New("failed to %v. (status code %v)", what, resp.StatusCode)
+}
+
+// call sends a request to the control API of the router server, see
+// newRequest and result.
+func (r *Router) call(ctx context.Context, method, path string, query url.Values, in, out interface{}, what string) error {
+	req, err := r.newRequest(ctx, method, path, query, in)
+	if err != nil {
+		return err
+	}
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return e.Forward(err)
+	}
+	return result(resp, out, what)
+}
+
+// register sends the route to the router server.
+func (r *Router) register(ctx context.Context, route *router.Route) (err error) {
 	var resp *http.Response
 	err = retry.Do(
 		func(_ context.Context) error {
-			var er error
-			resp, er = HTTPClient.Post(u.String(), "application/json", bytes.NewReader(buf))
+			req, er := r.newRequest(ctx, "POST", r.routesPath(), nil, route)
+			if er != nil {
+				return er
+			}
+			resp, er = HTTPClient.Do(req)
 			if er != nil {
 				return er
 			}
@@ -231,58 +414,127 @@ func (r *Router) handlerfunc(ctx context.Context, route *router.Route, handler h
 		err = e.Forward(err)
 		return
 	}
+	return result(resp, nil, "add a function handler to the router")
+}
 
-	defer resp.Body.Close()
-	switch resp.StatusCode {
-	case http.StatusCreated:
-		defer func() {
-			if err != nil {
-				return
-			}
-			r := recover()
-			switch x := r.(type) {
-			case error:
-				err = x
-			case string:
-				err = e.New(x)
-			default:
-				if x != nil {
-					err = e.New(x)
-				}
-			}
-		}()
-		r.router.Handle(route.Methode, route.Path, handler)
-		return
-	case 422:
-		response := &router.Response{}
-		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, BodyLimitSize))
+// SetWeight changes the weight of this host in all routes registered.
+func (r *Router) SetWeight(ctx context.Context, weight int) error {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	r.Weight = weight
+	for route := range r.routes {
+		route.Weight = weight
+		err := r.register(ctx, route)
 		if err != nil {
-			err = e.Forward(err)
-			return
+			return err
 		}
-		err = json.Unmarshal(body, response)
-		if err != nil {
-			err = e.Forward(err)
-			return
+	}
+	return nil
+}
+
+// renew extends the lease of the route in the router server.
+func (r *Router) renew(ctx context.Context, route *router.Route) error {
+	return r.call(ctx, "PATCH", r.routesPath()+"/backends", backendQuery(route), &router.BackendPatch{
+		TTL: &route.TTL,
+	}, nil, "renew the lease")
+}
+
+// Remove unregister this host from the route with method and path. The router
+// server will not send more requests for this host in that route.
+func (r *Router) Remove(ctx context.Context, method, path string) error {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	for route := range r.routes {
+		if route.Methode != method || route.Path != path {
+			continue
 		}
-		return response
-	case http.StatusInternalServerError:
-		operr := &router.OpErr{}
-		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, BodyLimitSize))
+		err := r.delRoute(ctx, route)
 		if err != nil {
-			err = e.Forward(err)
-			return
+			return err
 		}
-		err = json.Unmarshal(body, operr)
+		delete(r.routes, route)
+		return nil
+	}
+	return e.New("route not found")
+}
+
+// Close unregister all routes of this host from the router server and stops
+// the client. Close must be called before the service shutdown.
+func (r *Router) Close(ctx context.Context) error {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	if r.stop != nil {
+		close(r.stop)
+		r.stop = nil
+	}
+
+	var err error
+	for route := range r.routes {
+		er := r.delRoute(ctx, route)
+		if er != nil {
+			if err == nil {
+				err = er
+			}
+			continue
+		}
+		delete(r.routes, route)
+	}
+	return err
+}
+
+// Drain stops the requests for this host in the route with method and path.
+// The router server removes the host when the requests in flight finish, so
+// keep serving until then.
+func (r *Router) Drain(ctx context.Context, method, path string) error {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	for route := range r.routes {
+		if route.Methode != method || route.Path != path {
+			continue
+		}
+		err := r.drainRoute(ctx, route)
 		if err != nil {
-			err = e.Forward(err)
-			return
+			return err
 		}
-		return operr
-	default:
-		err = e.New("failed to add a function handler to the router. (status code %v)", resp.StatusCode)
-		return
+		delete(r.routes, route)
+		return nil
 	}
+	return e.New("route not found")
+}
+
+// drainRoute asks the router server to drain the route.
+func (r *Router) drainRoute(ctx context.Context, route *router.Route) error {
+	draining := true
+	return r.call(ctx, "PATCH", r.routesPath()+"/backends", backendQuery(route), &router.BackendPatch{
+		Draining: &draining,
+	}, nil, "drain the route")
+}
+
+// SetSplit changes the split of the requests between the tags of the servers
+// of the route with method and path. Nil removes the split.
+func (r *Router) SetSplit(ctx context.Context, method, path string, sp *router.SplitPolicy) error {
+	route := &router.Route{Methode: method, Path: path}
+	return r.call(ctx, "PUT", r.routesPath()+"/split", routeQuery(route), sp, nil, "split the route")
+}
+
+// SetRules replaces the rules of the route with method and path. The rules
+// send the requests to the servers of a tag. Nil removes the rules.
+func (r *Router) SetRules(ctx context.Context, method, path string, rules []*router.Rule) error {
+	route := &router.Route{Methode: method, Path: path}
+	return r.call(ctx, "PUT", r.routesPath()+"/rules", routeQuery(route), rules, nil, "set the rules of the route")
+}
+
+func (r *Router) delRoute(ctx context.Context, route *router.Route) (err error) {
+	defer func() {
+		if err != nil {
+			log.Errorf("Can't remove handler (%v, %v, %v) error: %v", route.Router, route.Methode, route.Path, err)
+		}
+	}()
+	return r.call(ctx, "DELETE", r.routesPath()+"/backends", backendQuery(route), nil, nil, "remove a function handler from the router")
 }
 
 func (r *Router) PathExist(path string) bool {
@@ -325,72 +577,12 @@ func (r *Router) GetRoutes(ctx context.Context) (router.Routes, error) {
 	return r.getRoutes(ctx, r.Router)
 }
 
-type Route struct {
-	Router string
-}
-
 func (r *Router) getRoutes(ctx context.Context, routeName string) (router.Routes, error) {
-	var body []byte
-
-	route := Route{
-		Router: routeName,
-	}
-
-	buf, err := json.Marshal(route)
-	if err != nil {
-		return nil, e.Forward(err)
-	}
-
-	u := neturl.Copy(r.URL)
-	u.Path = "/en/_router/get"
-	req, err := http.NewRequest("GET", u.String(), bytes.NewReader(buf))
-	if err != nil {
-		return nil, e.New(err)
-	}
-	req = req.WithContext(ctx)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := HTTPClient.Do(req)
+	var routes router.Routes
+	path := "/routers/" + url.PathEscape(routeName) + "/routes"
+	err := r.call(ctx, "GET", path, nil, nil, &routes, "get routes")
 	if err != nil {
-		return nil, e.Forward(err)
-	}
-	defer resp.Body.Close()
-
-	switch resp.StatusCode {
-	case http.StatusFound:
-		response := &router.ResponseRoutes{}
-		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, BodyLimitSize))
-		if err != nil {
-			return nil, e.Forward(err)
-		}
-		err = json.Unmarshal(body, response)
-		if err != nil {
-			return nil, e.Forward(err)
-		}
-		return response.Routes, nil
-	case 422:
-		response := &router.ResponseRoutes{}
-		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, BodyLimitSize))
-		if err != nil {
-			return nil, e.Forward(err)
-		}
-		err = json.Unmarshal(body, response)
-		if err != nil {
-			return nil, e.Forward(err)
-		}
-		return nil, response
-	case http.StatusInternalServerError:
-		operr := &router.OpErr{}
-		body, err = ioutil.ReadAll(io.LimitReader(resp.Body, BodyLimitSize))
-		if err != nil {
-			return nil, e.Forward(err)
-		}
-		err = json.Unmarshal(body, operr)
-		if err != nil {
-			return nil, e.Forward(err)
-		}
-		return nil, operr
-	default:
-		return nil, e.New("failed to get routes. (status code %v)", resp.StatusCode)
+		return nil, err
 	}
+	return routes, nil
 }
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"strconv"
@@ -41,6 +42,7 @@ func TestRouter(t *testing.T) {
 		ctx, _ = context.WithDeadline(ctx, time.Now().Add(100*time.Millisecond))
 		return ctx, nil
 	}
+	router.LeaseReapInterval = 100 * time.Millisecond
 	err := dr.Start(
 		routers,
 		router.NewRoundRobin(),
@@ -99,10 +101,6 @@ func TestClientRouter(t *testing.T) {
 	}
 }
 
-// func TestClientRouterClose(t *testing.T) {
-//   err := clientRouter.
-// }
-
 func TestClientHTTP(t *testing.T) {
 	h = &drouterhttp.HTTPServer{
 		HTTPAddr:           "localhost:8083",
@@ -419,17 +417,414 @@ func TestPUT(t *testing.T) {
 	}
 }
 
+func TestRemove(t *testing.T) {
+	err := clientRouter.GET(context.Background(), "/remove.txt", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprintf(rw, "%v", "teste")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := httpClient.Get(addrs + "remove.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("wrong status code,", resp.StatusCode)
+	}
+
+	err = clientRouter.Remove(context.Background(), "GET", "/remove.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = httpClient.Get(addrs + "remove.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatal("wrong status code,", resp.StatusCode)
+	}
+
+	err = clientRouter.Remove(context.Background(), "GET", "/remove.txt")
+	if err != nil && !e.Contains(err, "route not found") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestDrain(t *testing.T) {
+	err := clientRouter.GET(context.Background(), "/drain.txt", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprintf(rw, "%v", "teste")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = clientRouter.Drain(context.Background(), "GET", "/drain.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without requests in flight the router removes the host in the next
+	// reap.
+	time.Sleep(1500 * time.Millisecond)
+	resp, err := httpClient.Get(addrs + "drain.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatal("wrong status code,", resp.StatusCode)
+	}
+
+	err = clientRouter.Drain(context.Background(), "GET", "/drain.txt")
+	if err != nil && !e.Contains(err, "route not found") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestSetWeight(t *testing.T) {
+	err := clientRouter.SetWeight(context.Background(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := clientRouter.GetRoutes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, route := range routes {
+		if route.Methode != "GET" || route.Path != "/" {
+			continue
+		}
+		for _, b := range route.Backends {
+			if b.Addr != clientRouter.Addrs {
+				continue
+			}
+			found = true
+			if b.Weight != 2 {
+				t.Fatal("wrong weight", b.Weight)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("route not found")
+	}
+}
+
+func TestHandleRoute(t *testing.T) {
+	err := clientRouter.HandleRoute(context.Background(), &router.Route{
+		Methode:     "GET",
+		Path:        "/policy/*file",
+		Timeout:     time.Second,
+		StripPrefix: "/policy",
+	}, func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := clientRouter.GetRoutes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range routes {
+		if route.Path != "/policy/*file" {
+			continue
+		}
+		if route.Timeout != time.Second || route.StripPrefix != "/policy" {
+			t.Fatal("wrong options", route.Timeout, route.StripPrefix)
+		}
+		return
+	}
+	t.Fatal("route not found")
+}
+
+func TestSetSplit(t *testing.T) {
+	err := clientRouter.HandleRoute(context.Background(), &router.Route{
+		Methode: "GET",
+		Path:    "/split.txt",
+		Tag:     "v1",
+	}, func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clientRouter.SetSplit(context.Background(), "GET", "/split.txt", &router.SplitPolicy{
+		Weights: map[string]int{"v1": 95, "v2": 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := clientRouter.GetRoutes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range routes {
+		if route.Path != "/split.txt" {
+			continue
+		}
+		if route.Split == nil || route.Split.Weights["v2"] != 5 {
+			t.Fatal("wrong split", route.Split)
+		}
+		if len(route.Backends) != 1 || route.Backends[0].Tag != "v1" {
+			t.Fatal("wrong backends", route.Backends)
+		}
+		break
+	}
+
+	err = clientRouter.SetSplit(context.Background(), "GET", "/nosplit.txt", nil)
+	if err != nil && !e.Contains(err, "route not found") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestSetRules(t *testing.T) {
+	err := clientRouter.GET(context.Background(), "/rules.txt", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clientRouter.SetRules(context.Background(), "GET", "/rules.txt", []*router.Rule{
+		{Headers: map[string]string{"X-Api-Version": "2"}, Tag: "v2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := clientRouter.GetRoutes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range routes {
+		if route.Path != "/rules.txt" {
+			continue
+		}
+		if len(route.Rules) != 1 || route.Rules[0].Tag != "v2" {
+			t.Fatal("wrong rules", route.Rules)
+		}
+		break
+	}
+
+	err = clientRouter.SetRules(context.Background(), "GET", "/rules.txt", []*router.Rule{
+		{CIDRs: []string{"blurf"}},
+	})
+	if err != nil && !e.Contains(err, "invalid rule cidr") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestToken(t *testing.T) {
+	dr.SetControlAuth(&router.ControlAuth{
+		Identities: []*router.Identity{
+			{Name: "test", Token: "secret", Prefixes: []string{"/token"}},
+		},
+	})
+	defer dr.SetControlAuth(nil)
+	defer func() {
+		clientRouter.Token = ""
+	}()
+
+	handler := func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}
+	err := clientRouter.GET(context.Background(), "/token/a.txt", handler)
+	if err == nil {
+		t.Fatal("nil error")
+	}
+	clientRouter.Token = "secret"
+	err = clientRouter.GET(context.Background(), "/token/a.txt", handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = clientRouter.GET(context.Background(), "/notoken.txt", handler)
+	if err != nil && !e.Contains(err, "forbidden") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := clientRouter.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() router.Event {
+		for {
+			select {
+			case ev := <-events:
+				if ev.Path == "/watch.txt" {
+					return ev
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no event")
+			}
+		}
+	}
+
+	err = clientRouter.GET(context.Background(), "/watch.txt", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(); ev.Type != router.EventAdd || ev.Addr != clientRouter.Addrs || ev.Method != "GET" {
+		t.Fatal("wrong event", ev)
+	}
+	err = clientRouter.Remove(context.Background(), "GET", "/watch.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ev := next(); ev.Type != router.EventRemove || ev.Addr != clientRouter.Addrs {
+		t.Fatal("wrong event", ev)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestLease(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	u, _ := url.Parse("https://localhost:8082/")
+	cr := &Router{
+		Router: router.DefaultRouter,
+		URL:    u,
+		Addrs:  "https://localhost:8028",
+		TTL:    600 * time.Millisecond,
+	}
+	err := cr.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &drouterhttp.HTTPServer{
+		HTTPAddr:           "localhost:8027",
+		HTTPSAddr:          "localhost:8028",
+		Certificate:        "../device.crt",
+		PrivateKey:         "../device.key",
+		CA:                 "../rootCA.pem",
+		InsecureSkipVerify: true,
+		Handler:            cr,
+	}
+	err = client.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	err = cr.GET(ctx, "/lease.txt", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+		fmt.Fprintf(rw, "%v", "teste")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+
+	resp, err := httpClient.Get(addrs + "lease.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("wrong status code,", resp.StatusCode)
+	}
+
+	// Stop the renew of the lease.
+	cancel()
+
+	time.Sleep(1500 * time.Millisecond)
+
+	resp, err = httpClient.Get(addrs + "lease.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatal("wrong status code,", resp.StatusCode)
+	}
+}
+
+func TestRenewUnlocked(t *testing.T) {
+	renewing := make(chan struct{}, 1)
+	release := make(chan struct{})
+	r := httprouter.New()
+	r.POST(router.APIPrefix+"/routers/:router/routes", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusCreated)
+	})
+	r.PATCH(router.APIPrefix+"/routers/:router/routes/backends", func(rw http.ResponseWriter, req *http.Request) {
+		renewing <- struct{}{}
+		<-release
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	r.DELETE(router.APIPrefix+"/routers/:router/routes/backends", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusNoContent)
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+	defer close(release)
+
+	ctx := context.Background()
+	u, _ := url.Parse(server.URL + "/")
+	cr := &Router{
+		Router: router.DefaultRouter,
+		URL:    u,
+		Addrs:  "http://localhost:8009",
+		TTL:    time.Hour,
+	}
+	err := cr.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cr.Close(ctx)
+	err = cr.GET(ctx, "/renew", func(rw http.ResponseWriter, req *http.Request) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go cr.renewAll(ctx)
+	<-renewing
+	// The slow renew doesn't block the other methods.
+	done := make(chan error, 1)
+	go func() {
+		done <- cr.Remove(ctx, "GET", "/renew")
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("remove blocked by the renew")
+	}
+}
+
 func Test422(t *testing.T) {
 	r := httprouter.New()
-	r.POST("/_router/add", func(rw http.ResponseWriter, req *http.Request) {
-		rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	r.POST(router.APIPrefix+"/routers/:router/routes", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", router.ProblemContentType)
 		rw.WriteHeader(422)
-		resp := router.Response{
-			Methode: "GET",
-			Router:  "routerName",
-			Path:    "/",
-			Err:     "dummy error",
-			Op:      "ADD",
+		resp := router.Problem{
+			Title:  "Unprocessable Entity",
+			Status: 422,
+			Detail: "dummy error",
 		}
 		er := json.NewEncoder(rw).Encode(resp)
 		if er != nil {
@@ -482,8 +877,8 @@ func Test422(t *testing.T) {
 		fmt.Fprintf(rw, "%v", "teste")
 	})
 	if err != nil {
-		if resp, ok := err.(*router.Response); ok {
-			if resp.Err != "dummy error" {
+		if resp, ok := err.(*router.Problem); ok {
+			if resp.Detail != "dummy error" {
 				t.Fatal("invalid response")
 			}
 		} else {
@@ -503,12 +898,13 @@ func Test422(t *testing.T) {
 
 func Test500(t *testing.T) {
 	r := httprouter.New()
-	r.POST("/_router/add", func(rw http.ResponseWriter, req *http.Request) {
-		rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	r.POST(router.APIPrefix+"/routers/:router/routes", func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", router.ProblemContentType)
 		rw.WriteHeader(500)
-		resp := router.OpErr{
-			Err: "some error",
-			Op:  "ADD",
+		resp := router.Problem{
+			Title:  "Internal Server Error",
+			Status: 500,
+			Detail: "some error",
 		}
 		er := json.NewEncoder(rw).Encode(resp)
 		if er != nil {
@@ -561,8 +957,8 @@ func Test500(t *testing.T) {
 		fmt.Fprintf(rw, "%v", "teste")
 	})
 	if err != nil {
-		if resp, ok := err.(*router.OpErr); ok {
-			if resp.Err != "some error" {
+		if resp, ok := err.(*router.Problem); ok {
+			if resp.Detail != "some error" {
 				t.Fatal("invalid response")
 			}
 		} else {
@@ -582,7 +978,7 @@ func Test500(t *testing.T) {
 
 func TestXXX(t *testing.T) {
 	r := httprouter.New()
-	r.POST("/_router/add", func(rw http.ResponseWriter, req *http.Request) {
+	r.POST(router.APIPrefix+"/routers/:router/routes", func(rw http.ResponseWriter, req *http.Request) {
 		rw.Header().Set("Content-Type", "application/json; charset=UTF-8")
 		rw.WriteHeader(404)
 	})
@@ -645,6 +1041,21 @@ func TestXXX(t *testing.T) {
 	}
 }
 
+func TestClientRouterClose(t *testing.T) {
+	err := clientRouter.Close(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := httpClient.Get(addrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatal("wrong status code,", resp.StatusCode)
+	}
+}
+
 func TestRouterClose(t *testing.T) {
 	err := dr.Stop()
 	if err != nil {
@@ -0,0 +1,128 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/fcavani/droute/router"
+	"github.com/fcavani/e"
+	log "github.com/fcavani/slog"
+)
+
+// WatchRetry is the delay before the watch connects again to the router
+// server.
+var WatchRetry = time.Second
+
+// Watch returns the changes in the routes of the router. If the connection
+// with the router server is lost the watch resumes after the last event
+// received, and if the router server doesn't have the events anymore, like
+// after it restarts, an event of type router.EventReset is sent. The channel
+// is closed when ctx is canceled.
+func (r *Router) Watch(ctx context.Context) (<-chan router.Event, error) {
+	resp, since, err := r.watch(ctx, 0, false)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan router.Event)
+	go func() {
+		defer close(ch)
+		for {
+			if resp != nil {
+				var err error
+				since, err = readEvents(ctx, resp, ch, since)
+				if ctx.Err() != nil {
+					return
+				}
+				log.Tag("client", "watch").Errorf("Watch of %v lost: %v", r.Router, err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(WatchRetry):
+			}
+			var err error
+			resp, since, err = r.watch(ctx, since, true)
+			if p, ok := err.(*router.Problem); ok && p.Status == http.StatusGone {
+				select {
+				case ch <- router.Event{Type: router.EventReset, Router: r.Router, Time: time.Now()}:
+				case <-ctx.Done():
+					return
+				}
+				resp, since, err = r.watch(ctx, 0, false)
+			}
+			if err != nil {
+				log.Tag("client", "watch").Errorf("Can't watch %v: %v", r.Router, err)
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// watch starts the stream of events of the router server, after the revision
+// since if resume is true. It returns the revision the stream starts, since
+// if the watch fails.
+func (r *Router) watch(ctx context.Context, since uint64, resume bool) (*http.Response, uint64, error) {
+	query := url.Values{"router": {r.Router}}
+	if resume {
+		query.Set("since", strconv.FormatUint(since, 10))
+	}
+	req, err := r.newRequest(ctx, "GET", "/watch", query, nil)
+	if err != nil {
+		return nil, since, err
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return nil, since, e.Forward(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, since, result(resp, nil, "watch the routes")
+	}
+	if !resume {
+		since, err = strconv.ParseUint(resp.Header.Get(router.RevisionHeader), 10, 64)
+		if err != nil {
+			resp.Body.Close()
+			return nil, 0, e.Push(err, "invalid revision")
+		}
+	}
+	return resp, since, nil
+}
+
+// readEvents sends the events of the stream in resp to ch until the stream
+// ends, and returns the revision of the last event.
+func readEvents(ctx context.Context, resp *http.Response, ch chan<- router.Event, since uint64) (uint64, error) {
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			// Keep alive.
+			continue
+		}
+		var ev router.Event
+		err := json.Unmarshal(line, &ev)
+		if err != nil {
+			return since, e.Forward(err)
+		}
+		select {
+		case ch <- ev:
+			since = ev.Revision
+		case <-ctx.Done():
+			return since, ctx.Err()
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = e.New("stream closed")
+	}
+	return since, err
+}
@@ -17,12 +17,16 @@ import (
 
 // HTTPServer is a http and https server.
 type HTTPServer struct {
+	// HTTPAddr is the address of the http server. Empty disables it.
 	HTTPAddr           string
 	HTTPSAddr          string
 	Certificate        string
 	PrivateKey         string
 	CA                 string
 	InsecureSkipVerify bool
+	// ClientAuth is the policy for the client certificates of the https
+	// server. They are verified with CA.
+	ClientAuth tls.ClientAuthType
 
 	Handler http.Handler
 
@@ -35,17 +39,19 @@ func (h *HTTPServer) Init() error {
 	var err error
 	// Start the listners, open the doors
 	// http
-	log.Tag("router").Println("Setup http server...")
-	h.lnHTTP, err = net.Listen("tcp", h.HTTPAddr)
-	if err != nil {
-		return e.Forward(err)
-	}
-	go func() {
-		err = http.Serve(h.lnHTTP, h.Handler)
+	if h.HTTPAddr != "" {
+		log.Tag("router").Println("Setup http server...")
+		h.lnHTTP, err = net.Listen("tcp", h.HTTPAddr)
 		if err != nil {
-			log.Tag("router").Errorln("ListenAndServe failed:", err)
+			return e.Forward(err)
 		}
-	}()
+		go func() {
+			err := http.Serve(h.lnHTTP, h.Handler)
+			if err != nil {
+				log.Tag("router").Errorln("ListenAndServe failed:", err)
+			}
+		}()
+	}
 	//https
 	if !(h.Certificate == "" || h.PrivateKey == "") {
 		log.Tag("router").Println("Setup https server...")
@@ -71,6 +77,8 @@ func (h *HTTPServer) Init() error {
 			TLSConfig: &tls.Config{
 				InsecureSkipVerify: h.InsecureSkipVerify,
 				RootCAs:            CAPool,
+				ClientCAs:          CAPool,
+				ClientAuth:         h.ClientAuth,
 				Certificates:       certs,
 			},
 			Handler: h.Handler,
@@ -96,23 +104,35 @@ func (h *HTTPServer) Init() error {
 
 // Stop halts the router and close the listners.
 func (h *HTTPServer) Stop() error {
-	err := h.lnHTTP.Close()
-	if err != nil {
-		return e.Forward(err)
+	if h.lnHTTP != nil {
+		err := h.lnHTTP.Close()
+		if err != nil {
+			return e.Forward(err)
+		}
 	}
-	err = h.lnHTTPS.Close()
-	if err != nil {
-		return e.Forward(err)
+	if h.lnHTTPS != nil {
+		err := h.lnHTTPS.Close()
+		if err != nil {
+			return e.Forward(err)
+		}
 	}
 	return nil
 }
 
-// GetHTTPAddr get the bind address of the http server.
+// GetHTTPAddr get the bind address of the http server. It's empty if the http
+// server isn't running.
 func (h *HTTPServer) GetHTTPAddr() string {
+	if h.lnHTTP == nil {
+		return ""
+	}
 	return h.lnHTTP.Addr().String()
 }
 
-// GetHTTPSAddr get the bind address of the https server.
+// GetHTTPSAddr get the bind address of the https server. It's empty if the
+// https server isn't running.
 func (h *HTTPServer) GetHTTPSAddr() string {
+	if h.lnHTTPS == nil {
+		return ""
+	}
 	return h.lnHTTPS.Addr().String()
 }
@@ -75,3 +75,39 @@ func TestHTTP(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestHTTPSOnly(t *testing.T) {
+	router := httprouter.New()
+	router.GET("/", func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(200)
+		fmt.Fprint(rw, "oi")
+	})
+
+	hs := &HTTPServer{
+		HTTPSAddr:          "localhost:0",
+		Certificate:        "../device.crt",
+		PrivateKey:         "../device.key",
+		CA:                 "../rootCA.pem",
+		InsecureSkipVerify: true,
+		Handler:            router,
+	}
+
+	err := hs.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hs.Stop()
+
+	if addr := hs.GetHTTPAddr(); addr != "" {
+		t.Fatal("http server running", addr)
+	}
+
+	resp, err := httpClient.Get("https://" + hs.GetHTTPSAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("wrong status code,", resp.StatusCode)
+	}
+}
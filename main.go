@@ -6,6 +6,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -161,8 +162,17 @@ func main() {
 	// Redir GET from anything to domain.com
 	routers.Set("redir", router.NewRedirHostRouter("domain.com"))
 
-	// LoadBalance strategy: round robin.
-	lb := router.NewRoundRobin()
+	// LoadBalance strategy: round robin. Others are NewLeastConnections,
+	// NewRandom, NewP2C, NewWeightedRoundRobin and NewConsistentHash.
+	// The servers added warm up during the slow start window and the ones
+	// that fail consecutive requests are ejected for a while. Readmitted
+	// servers warm up again when slow start is enabled. Each route has a
+	// clone of it, see also Router.SetBalancer.
+	var rr router.LoadBalance = router.NewRoundRobin()
+	if window := viper.GetDuration("slowstart"); window > 0 {
+		rr = router.NewSlowStart(rr, window)
+	}
+	lb := router.NewOutlierDetection(rr, nil)
 
 	// The router.
 	r := &router.Router{}
@@ -172,6 +182,24 @@ func main() {
 	}
 	defer r.Stop()
 
+	// Active health check of the servers, only if the path is configured.
+	if path := viper.GetStringMapString("healthcheck")["path"]; path != "" {
+		err = r.HealthCheck(&router.HealthCheck{
+			Path: path,
+		})
+		if err != nil {
+			log.Tag("startup", "services", *name).Fatalln(err)
+		}
+	}
+
+	// Trust the forwarding headers only if the router is behind another proxy.
+	if viper.GetBool("trustforwardheaders") {
+		err = r.TrustForwardHeaders(router.DefaultRouter, true)
+		if err != nil {
+			log.Tag("startup", "services", *name).Fatalln(err)
+		}
+	}
+
 	// HTTPHandlers example and bucket rate limit usage.
 	r.HTTPHandlers(func(first http.Handler) http.Handler {
 		return bucket.NewBucket(
@@ -190,6 +218,22 @@ func main() {
 	r.SetHostSwitch("domain.com", router.DefaultRouter)
 	r.SetHostSwitch("www.domain.com", "redir")
 
+	// Keep the routes between restarts, only if the file is configured. The
+	// routes are restored after the router is configured, their handlers are
+	// built with the configuration when they are added.
+	if file := viper.GetStringMapString("store")["file"]; file != "" {
+		store, err := router.OpenBoltStore(file, 0600, nil)
+		if err != nil {
+			log.Tag("startup", "services", *name).Fatalln(err)
+		}
+		defer store.Close()
+		r.SetStore(store, viper.GetBool("store.unverified"))
+		err = r.Restore()
+		if err != nil {
+			log.Tag("startup", "services", *name).Fatalln(err)
+		}
+	}
+
 	h := &drouterhttp.HTTPServer{
 		HTTPAddr:           viper.GetStringMapString("http")["bindaddrs"],
 		HTTPSAddr:          viper.GetStringMapString("https")["bindaddrs"],
@@ -206,8 +250,43 @@ func main() {
 	}
 	defer h.Stop()
 
-	r.SetHTTPAddr(h.GetHTTPAddr())
-	r.SetHTTPSAddr(h.GetHTTPSAddr())
+	if addr := h.GetHTTPAddr(); addr != "" {
+		r.SetHTTPAddr(addr)
+	}
+	if addr := h.GetHTTPSAddr(); addr != "" {
+		r.SetHTTPSAddr(addr)
+	}
+
+	// Control API in a separate address with client certificates and bearer
+	// tokens. Only served with TLS.
+	if admin := viper.GetStringMapString("admin"); admin["bindaddrs"] != "" {
+		if admin["certificate"] == "" || admin["privatekey"] == "" {
+			log.Tag("startup", "services", *name).Fatalln("admin address without certificate or private key")
+		}
+		auth := &router.ControlAuth{}
+		err = viper.UnmarshalKey("admin", auth)
+		if err != nil {
+			log.Tag("startup", "services", *name).Fatalln(err)
+		}
+		r.SetControlAuth(auth)
+		clientAuth := tls.NoClientCert
+		if viper.GetBool("admin.clientcerts") {
+			clientAuth = tls.VerifyClientCertIfGiven
+		}
+		ah := &drouterhttp.HTTPServer{
+			HTTPSAddr:   admin["bindaddrs"],
+			Certificate: admin["certificate"],
+			PrivateKey:  admin["privatekey"],
+			CA:          admin["ca"],
+			ClientAuth:  clientAuth,
+			Handler:     r.ControlHandler(),
+		}
+		err = ah.Init()
+		if err != nil {
+			log.Tag("startup", "services", *name).Fatalln(err)
+		}
+		defer ah.Stop()
+	}
 
 	// a, err := host(lnHTTP.Addr())
 	// if err != nil {
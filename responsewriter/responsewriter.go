@@ -5,18 +5,33 @@
 package responsewriter
 
 import (
+	"bufio"
 	"bytes"
+	"io"
+	"net"
 	"net/http"
 
 	"github.com/fcavani/e"
 	log "github.com/fcavani/slog"
 )
 
-// ResponseWriter implements the ResponseWriter interface
+// ResponseWriter implements the ResponseWriter interface. The handlers write
+// the response in a buffer that Copy sends to the client, so the middlewares
+// can inspect it with Bytes. The body set with SetBody isn't in the buffer and
+// the data sent to the client by Flush is removed from it.
 type ResponseWriter struct {
 	header http.Header
 	code   int
 	buffer *bytes.Buffer
+	// stream is true if the body can be streamed by Copy.
+	stream bool
+	body   io.ReadCloser
+	// dst is the client ResponseWriter, used to hijack the connection and to
+	// flush.
+	dst      http.ResponseWriter
+	hijacked bool
+	// flushed is true if the header was sent to dst by Flush.
+	flushed bool
 }
 
 //NewResponseWriter creates a new ResponseWriter
@@ -27,6 +42,106 @@ func NewResponseWriter() *ResponseWriter {
 	}
 }
 
+// NewStreamResponseWriter creates a new ResponseWriter that accepts a body to
+// be streamed, see SetBody.
+func NewStreamResponseWriter() *ResponseWriter {
+	rw := NewResponseWriter()
+	rw.stream = true
+	return rw
+}
+
+// Stream returns true if the body set by SetBody will be streamed to the
+// client. If false the handlers must write the body in the buffer.
+func (rw *ResponseWriter) Stream() bool {
+	return rw.stream
+}
+
+// SetBody sets the body that Copy will stream to the client after the buffer.
+// The previous body, if any, is closed. Nil removes the body.
+func (rw *ResponseWriter) SetBody(body io.ReadCloser) {
+	if rw.body != nil {
+		err := rw.body.Close()
+		if err != nil {
+			log.Tag("responsewriter").Error(err)
+		}
+	}
+	rw.body = body
+}
+
+// TakeBody returns the body set by SetBody and removes it from rw, the caller
+// must close it.
+func (rw *ResponseWriter) TakeBody() io.ReadCloser {
+	body := rw.body
+	rw.body = nil
+	return body
+}
+
+// Flush sends the header and the buffer to the client if the ResponseWriter
+// was created by Handler and the client connection is a http.Flusher,
+// otherwise it does nothing and the data is sent by Copy. The buffer is empty
+// after that and the header and the response code can't be changed anymore.
+func (rw *ResponseWriter) Flush() {
+	if rw.hijacked {
+		return
+	}
+	flusher, ok := rw.dst.(http.Flusher)
+	if !ok {
+		return
+	}
+	err := rw.send(rw.dst)
+	rw.buffer.Reset()
+	if err != nil {
+		log.Tag("responsewriter").Error(err)
+		return
+	}
+	flusher.Flush()
+}
+
+// send sends the header, if it wasn't sent yet, and the buffer to dst.
+func (rw *ResponseWriter) send(dst http.ResponseWriter) error {
+	if !rw.flushed {
+		header := dst.Header()
+		for k, v := range rw.header {
+			for _, item := range v {
+				header.Add(k, item)
+			}
+		}
+		if rw.code != 0 {
+			dst.WriteHeader(rw.code)
+		}
+	}
+	rw.flushed = true
+	l := rw.buffer.Len()
+	n, err := dst.Write(rw.buffer.Bytes())
+	if err != nil {
+		return e.Forward(err)
+	}
+	if n != l {
+		return e.New("didn't wrote all data")
+	}
+	return nil
+}
+
+// Hijack lets the caller take over the client connection. After that Copy
+// does nothing.
+func (rw *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.dst.(http.Hijacker)
+	if !ok {
+		return nil, nil, e.New("connection can't be hijacked")
+	}
+	conn, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, e.Forward(err)
+	}
+	rw.hijacked = true
+	return conn, brw, nil
+}
+
+// Hijacked returns true if the client connection was hijacked.
+func (rw *ResponseWriter) Hijacked() bool {
+	return rw.hijacked
+}
+
 // Bytes resturn a slice with the current buffer.
 func (rw *ResponseWriter) Bytes() []byte {
 	return rw.buffer.Bytes()
@@ -65,41 +180,69 @@ func (rw *ResponseWriter) Read(p []byte) (int, error) {
 // Copy the data from the ResponseWriter struct to the
 // ResponseWriter interface used in the http package.
 func (rw *ResponseWriter) Copy(dst http.ResponseWriter) error {
-	header := dst.Header()
-	for k, v := range rw.header {
-		for _, item := range v {
-			header.Add(k, item)
-		}
+	if rw.hijacked {
+		return nil
 	}
-	if rw.code != 0 {
-		dst.WriteHeader(rw.code)
-	}
-	l := rw.buffer.Len()
-	n, err := dst.Write(rw.buffer.Bytes())
+	err := rw.send(dst)
 	if err != nil {
 		return e.Forward(err)
 	}
-	if n != l {
-		return e.New("didn't wrote all data")
+	if rw.body == nil {
+		return nil
+	}
+	body := rw.body
+	rw.body = nil
+	defer body.Close()
+	return stream(dst, body)
+}
+
+// StreamBufferSize is the size of the chunks read from a streamed body.
+var StreamBufferSize = 32 * 1024
+
+// stream copies body to dst flushing each chunk if dst is a http.Flusher.
+func stream(dst http.ResponseWriter, body io.Reader) error {
+	flusher, _ := dst.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	buf := make([]byte, StreamBufferSize)
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			_, er := dst.Write(buf[:n])
+			if er != nil {
+				return e.Forward(er)
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return e.Forward(err)
+		}
 	}
-	return nil
 }
 
-// Reset the buffer.
+// Reset the buffer. The data already sent by Flush isn't affected.
 func (rw *ResponseWriter) Reset() {
 	rw.code = 0
 	rw.header = make(map[string][]string)
 	rw.buffer = bytes.NewBuffer([]byte{})
+	rw.SetBody(nil)
 }
 
 // HandlerFunc is the function signature for the http handler with a more flexible
 // ResponseWriter.
 type HandlerFunc func(*ResponseWriter, *http.Request)
 
-// Handler is a adaptor from http handler to responsewrite handler.
+// Handler is a adaptor from http handler to responsewrite handler. The body set
+// with SetBody is streamed to the client and the connection can be hijacked.
 func Handler(handle HandlerFunc) http.HandlerFunc {
 	return func(rw http.ResponseWriter, req *http.Request) {
-		w := NewResponseWriter()
+		w := NewStreamResponseWriter()
+		w.dst = rw
 		handle(w, req)
 		err := w.Copy(rw)
 		if err != nil {
@@ -7,6 +7,8 @@ package responsewriter
 import (
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -95,3 +97,107 @@ func TestHandler(t *testing.T) {
 		t.Fatal("wrong code", code)
 	}
 }
+
+type body struct {
+	*strings.Reader
+	closed bool
+}
+
+func (b *body) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestStream(t *testing.T) {
+	rw := NewResponseWriter()
+	if rw.Stream() {
+		t.Fatal("buffered response writer can stream")
+	}
+	rw = NewStreamResponseWriter()
+	if !rw.Stream() {
+		t.Fatal("response writer can't stream")
+	}
+	rw.Header().Add("foo", "bar")
+	rw.WriteHeader(200)
+	rw.Write([]byte("head "))
+	b := &body{Reader: strings.NewReader("streamed body")}
+	rw.SetBody(b)
+
+	dst := httptest.NewRecorder()
+	err := rw.Copy(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.closed {
+		t.Fatal("body not closed")
+	}
+	if !dst.Flushed {
+		t.Fatal("not flushed")
+	}
+	if dst.Code != 200 {
+		t.Fatal("wrong response code", dst.Code)
+	}
+	if v := dst.Header().Get("foo"); v != "bar" {
+		t.Fatal("header didn´t work, wrong value", v)
+	}
+	if str := dst.Body.String(); str != "head streamed body" {
+		t.Fatal("wrong body", str)
+	}
+
+	b = &body{Reader: strings.NewReader("discarded")}
+	rw.SetBody(b)
+	rw.Reset()
+	if !b.closed {
+		t.Fatal("body not closed")
+	}
+	dst = httptest.NewRecorder()
+	err = rw.Copy(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dst.Body.Len() != 0 {
+		t.Fatal("body not discarded", dst.Body.String())
+	}
+}
+
+func TestFlush(t *testing.T) {
+	r, err := http.NewRequest("GET", "http://localhost", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := httptest.NewRecorder()
+	h := Handler(func(rw *ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/event-stream")
+		rw.WriteHeader(http.StatusAccepted)
+		rw.Write([]byte("data: 1\n\n"))
+		rw.Flush()
+		if !dst.Flushed {
+			t.Fatal("not flushed")
+		}
+		if str := dst.Body.String(); str != "data: 1\n\n" {
+			t.Fatal("wrong body", str)
+		}
+		if rw.Len() != 0 {
+			t.Fatal("flushed data still in the buffer", string(rw.Bytes()))
+		}
+		rw.Write([]byte("data: 2\n\n"))
+	})
+	h(dst, r)
+	if dst.Code != http.StatusAccepted {
+		t.Fatal("wrong response code", dst.Code)
+	}
+	if v := dst.Header().Get("Content-Type"); v != "text/event-stream" {
+		t.Fatal("wrong header", dst.Header())
+	}
+	if str := dst.Body.String(); str != "data: 1\n\ndata: 2\n\n" {
+		t.Fatal("wrong body", str)
+	}
+
+	// Without a client connection the data stays in the buffer.
+	rw := NewResponseWriter()
+	rw.Write([]byte("data"))
+	rw.Flush()
+	if str := string(rw.Bytes()); str != "data" {
+		t.Fatal("wrong buffer", str)
+	}
+}
@@ -0,0 +1,487 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fcavani/e"
+	log "github.com/fcavani/slog"
+)
+
+// APIPrefix is the path of the version 1 of the control API. It is served
+// without the language prefix and described by the OpenAPI document in
+// APIPrefix + "/openapi.json".
+const APIPrefix = "/_router/v1"
+
+// ProblemContentType is the content type of the errors of the control API.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an error of the control API, see RFC 7807.
+type Problem struct {
+	Type   string `json:"type,omitempty"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+	return p.Detail
+}
+
+// problem writes the error err with the status code in the format of the
+// RFC 7807.
+func problem(w http.ResponseWriter, code int, err error) {
+	p := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(code),
+		Status: code,
+	}
+	if err != nil {
+		p.Detail = err.Error()
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(code)
+	er := json.NewEncoder(w).Encode(p)
+	if er != nil {
+		log.Tag("router", "server", "rest").Error(er)
+	}
+}
+
+// apiStatus returns the status code of an error returned by the methods of
+// the Router.
+func apiStatus(err error) int {
+	if e.Contains(err, "not found") {
+		return http.StatusNotFound
+	}
+	if e.Contains(err, "route exists with other") {
+		return http.StatusConflict
+	}
+	return 422 // unprocessable entity
+}
+
+// reply writes v in json with the status code. Nil writes no body.
+func reply(w http.ResponseWriter, code int, v interface{}) {
+	if v == nil {
+		w.WriteHeader(code)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	er := json.NewEncoder(w).Encode(v)
+	if er != nil {
+		log.Tag("router", "server", "rest").Error(er)
+	}
+}
+
+// decode reads the json body of the request in v.
+func decode(req *http.Request, v interface{}) error {
+	defer req.Body.Close()
+	err := json.NewDecoder(io.LimitReader(req.Body, BodyLimitSize)).Decode(v)
+	if err != nil {
+		return e.Push(err, "invalid body")
+	}
+	return nil
+}
+
+// routeQuery returns the method and the path of the route in the query
+// parameters method and path.
+func routeQuery(req *http.Request) (method, path string, err error) {
+	q := req.URL.Query()
+	method, path = q.Get("method"), q.Get("path")
+	if method == "" || path == "" {
+		return "", "", e.New("method and path are required")
+	}
+	return method, path, nil
+}
+
+// backendQuery returns the route and the address of the server in the query
+// parameters method, path and addr.
+func backendQuery(req *http.Request) (method, path, addr string, err error) {
+	method, path, err = routeQuery(req)
+	if err != nil {
+		return
+	}
+	addr = req.URL.Query().Get("addr")
+	if addr == "" {
+		err = e.New("addr is required")
+	}
+	return
+}
+
+// BackendPatch changes the state of a server of a route. The nil fields are
+// kept.
+type BackendPatch struct {
+	// Weight of the server. Zero is the same as one.
+	Weight *int `json:",omitempty"`
+	// TTL renews the lease of the server. Zero removes the lease.
+	TTL *time.Duration `json:",omitempty"`
+	// Draining true drains the server, see Drain. Add the server again to
+	// stop the drain.
+	Draining *bool `json:",omitempty"`
+}
+
+// apiSwitch sends the requests of the control API v1 to it and the others to
+// next. admin is true for the handler of ControlHandler.
+func (r *Router) apiSwitch(admin bool, next http.Handler) http.Handler {
+	api := r.control(admin, problem, r.api)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == APIPrefix || strings.HasPrefix(req.URL.Path, APIPrefix+"/") {
+			api(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// watchSwitch sends the watches of the control API v1 to it and the other
+// requests to next.
+func (r *Router) watchSwitch(next http.Handler) http.Handler {
+	api := r.control(false, problem, r.api)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, APIPrefix+"/") && strings.Trim(strings.TrimPrefix(req.URL.Path, APIPrefix), "/") == "watch" {
+			api(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// apiHandlers are the handlers of one resource by method.
+type apiHandlers map[string]func(w http.ResponseWriter, req *http.Request, routerName string)
+
+// api serves the control API v1:
+//
+//	GET    /openapi.json
+//	GET    /routers
+//	GET    /routers/{router}/routes?method=&path=&tag=
+//	POST   /routers/{router}/routes
+//	DELETE /routers/{router}/routes?method=&path=
+//	POST   /routers/{router}/routes/backends?method=&path=
+//	PATCH  /routers/{router}/routes/backends?method=&path=&addr=
+//	DELETE /routers/{router}/routes/backends?method=&path=&addr=
+//	PUT    /routers/{router}/routes/split?method=&path=
+//	PUT    /routers/{router}/routes/rules?method=&path=
+//	GET    /watch?router=&since=
+func (r *Router) api(w http.ResponseWriter, req *http.Request) {
+	segs := strings.Split(strings.Trim(strings.TrimPrefix(req.URL.Path, APIPrefix), "/"), "/")
+	var handlers apiHandlers
+	var routerName string
+	switch {
+	case len(segs) == 1 && segs[0] == "openapi.json":
+		handlers = apiHandlers{"GET": apiOpenAPI}
+	case len(segs) == 1 && segs[0] == "routers":
+		handlers = apiHandlers{"GET": r.apiRouters}
+	case len(segs) == 1 && segs[0] == "watch":
+		handlers = apiHandlers{"GET": r.apiWatch}
+	case len(segs) >= 3 && segs[0] == "routers" && segs[2] == "routes":
+		routerName = segs[1]
+		switch strings.Join(segs[3:], "/") {
+		case "":
+			handlers = apiHandlers{
+				"GET":    r.apiRoutes,
+				"POST":   r.apiAddRoute,
+				"DELETE": r.apiDelRoute,
+			}
+		case "backends":
+			handlers = apiHandlers{
+				"POST":   r.apiAddBackend,
+				"PATCH":  r.apiPatchBackend,
+				"DELETE": r.apiDelBackend,
+			}
+		case "split":
+			handlers = apiHandlers{"PUT": r.apiSplit}
+		case "rules":
+			handlers = apiHandlers{"PUT": r.apiRules}
+		}
+	}
+	if handlers == nil {
+		problem(w, http.StatusNotFound, e.New("not found"))
+		return
+	}
+	f, found := handlers[req.Method]
+	if !found {
+		allow := make([]string, 0, len(handlers))
+		for method := range handlers {
+			allow = append(allow, method)
+		}
+		sort.Strings(allow)
+		w.Header().Set("Allow", strings.Join(allow, ", "))
+		problem(w, http.StatusMethodNotAllowed, e.New("method not allowed"))
+		return
+	}
+	f(w, req, routerName)
+}
+
+func apiOpenAPI(w http.ResponseWriter, req *http.Request, _ string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	_, err := io.WriteString(w, openAPI)
+	if err != nil {
+		log.Tag("router", "server", "rest").Error(err)
+	}
+}
+
+// apiRouters returns the names of the routers.
+func (r *Router) apiRouters(w http.ResponseWriter, req *http.Request, _ string) {
+	id := identity(req)
+	names := make([]string, 0, len(r.routers))
+	for name := range r.routers {
+		if id.allowedRouter(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	reply(w, http.StatusOK, names)
+}
+
+// apiRoutes returns the routes of the router, filtered by the query parameters
+// method, path and tag.
+func (r *Router) apiRoutes(w http.ResponseWriter, req *http.Request, routerName string) {
+	id := identity(req)
+	if !id.allowedRouter(routerName) {
+		problem(w, http.StatusForbidden, e.New("forbidden"))
+		return
+	}
+	rs, err := r.Get(routerName)
+	if err != nil {
+		problem(w, apiStatus(err), err)
+		return
+	}
+	q := req.URL.Query()
+	method, path := q.Get("method"), q.Get("path")
+	tag, byTag := q["tag"]
+	routes := make(Routes, 0, len(rs))
+	for _, rt := range rs {
+		if (method != "" && rt.Methode != method) || (path != "" && rt.Path != path) {
+			continue
+		}
+		if byTag && !rt.tagged(tag[0]) {
+			continue
+		}
+		if !id.allowedPath(rt.Path) {
+			continue
+		}
+		routes = append(routes, rt)
+	}
+	reply(w, http.StatusOK, routes)
+}
+
+// tagged returns true if the route has a server with the tag.
+func (rt *Route) tagged(tag string) bool {
+	for _, b := range rt.Backends {
+		if b.Tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Router) apiAddRoute(w http.ResponseWriter, req *http.Request, routerName string) {
+	var route Route
+	err := decode(req, &route)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	if route.Router != "" && route.Router != routerName {
+		problem(w, 422, e.New("router of the route isn't %v", routerName))
+		return
+	}
+	route.Router = routerName
+	err = authorize(req, route.Router, route.Path)
+	if err != nil {
+		problem(w, http.StatusForbidden, err)
+		return
+	}
+	err = r.Register(&route)
+	if err != nil {
+		problem(w, apiStatus(err), err)
+		return
+	}
+	q := url.Values{"method": {route.Methode}, "path": {route.Path}}
+	w.Header().Set("Location", APIPrefix+"/routers/"+url.PathEscape(routerName)+"/routes?"+q.Encode())
+	reply(w, http.StatusCreated, &route)
+}
+
+func (r *Router) apiDelRoute(w http.ResponseWriter, req *http.Request, routerName string) {
+	method, path, err := routeQuery(req)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	err = authorize(req, routerName, path)
+	if err != nil {
+		problem(w, http.StatusForbidden, err)
+		return
+	}
+	err = r.DelRoute(routerName, method, path)
+	if err != nil {
+		problem(w, apiStatus(err), err)
+		return
+	}
+	reply(w, http.StatusNoContent, nil)
+}
+
+// apiAddBackend adds a server to an existing route or updates it.
+func (r *Router) apiAddBackend(w http.ResponseWriter, req *http.Request, routerName string) {
+	method, path, err := routeQuery(req)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	var b Backend
+	err = decode(req, &b)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	err = authorize(req, routerName, path)
+	if err != nil {
+		problem(w, http.StatusForbidden, err)
+		return
+	}
+	if !r.active(routeKey{router: routerName, method: method, path: path}) {
+		problem(w, http.StatusNotFound, e.New("route not found"))
+		return
+	}
+	err = r.Register(&Route{
+		Methode: method,
+		Router:  routerName,
+		Path:    path,
+		RedirTo: b.Addr,
+		TTL:     b.TTL,
+		Weight:  b.Weight,
+		Tag:     b.Tag,
+	})
+	if err != nil {
+		problem(w, apiStatus(err), err)
+		return
+	}
+	reply(w, http.StatusCreated, &b)
+}
+
+// apiPatchBackend changes the weight, the lease or drains a server.
+func (r *Router) apiPatchBackend(w http.ResponseWriter, req *http.Request, routerName string) {
+	method, path, addr, err := backendQuery(req)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	var patch BackendPatch
+	err = decode(req, &patch)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	if patch.Draining != nil && !*patch.Draining {
+		problem(w, 422, e.New("the drain can't be stopped, add the server again"))
+		return
+	}
+	err = authorize(req, routerName, path)
+	if err != nil {
+		problem(w, http.StatusForbidden, err)
+		return
+	}
+	if patch.Weight != nil {
+		err = r.SetWeight(routerName, method, path, addr, *patch.Weight)
+		if err != nil {
+			problem(w, apiStatus(err), err)
+			return
+		}
+	}
+	if patch.TTL != nil {
+		err = r.Renew(routerName, method, path, addr, *patch.TTL)
+		if err != nil {
+			problem(w, apiStatus(err), err)
+			return
+		}
+	}
+	if patch.Draining != nil {
+		err = r.Drain(routerName, method, path, addr)
+		if err != nil {
+			problem(w, apiStatus(err), err)
+			return
+		}
+	}
+	reply(w, http.StatusNoContent, nil)
+}
+
+func (r *Router) apiDelBackend(w http.ResponseWriter, req *http.Request, routerName string) {
+	method, path, addr, err := backendQuery(req)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	err = authorize(req, routerName, path)
+	if err != nil {
+		problem(w, http.StatusForbidden, err)
+		return
+	}
+	err = r.Del(routerName, method, path, addr)
+	if err != nil {
+		problem(w, apiStatus(err), err)
+		return
+	}
+	reply(w, http.StatusNoContent, nil)
+}
+
+func (r *Router) apiSplit(w http.ResponseWriter, req *http.Request, routerName string) {
+	method, path, err := routeQuery(req)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	var sp *SplitPolicy
+	err = decode(req, &sp)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	err = authorize(req, routerName, path)
+	if err != nil {
+		problem(w, http.StatusForbidden, err)
+		return
+	}
+	err = r.SetSplit(routerName, method, path, sp)
+	if err != nil {
+		problem(w, apiStatus(err), err)
+		return
+	}
+	reply(w, http.StatusNoContent, nil)
+}
+
+func (r *Router) apiRules(w http.ResponseWriter, req *http.Request, routerName string) {
+	method, path, err := routeQuery(req)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	var rules []*Rule
+	err = decode(req, &rules)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	err = authorize(req, routerName, path)
+	if err != nil {
+		problem(w, http.StatusForbidden, err)
+		return
+	}
+	err = r.SetRules(routerName, method, path, rules)
+	if err != nil {
+		problem(w, apiStatus(err), err)
+		return
+	}
+	reply(w, http.StatusNoContent, nil)
+}
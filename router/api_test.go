@@ -0,0 +1,213 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAPI(t *testing.T) {
+	r := &Router{}
+	err := r.Start(NewRouters(), NewWeightedRoundRobin(), time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	api := func(method, path string, q url.Values, body interface{}) *httptest.ResponseRecorder {
+		var buf []byte
+		if body != nil {
+			buf, err = json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		u := "http://localhost" + APIPrefix + path
+		if q != nil {
+			u += "?" + q.Encode()
+		}
+		req := httptest.NewRequest(method, u, bytes.NewReader(buf))
+		req.Header.Set("X-Real-Ip", "127.0.0.1")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	isProblem := func(w *httptest.ResponseRecorder, code int) *Problem {
+		t.Helper()
+		if w.Code != code {
+			t.Fatal("wrong status code", w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != ProblemContentType {
+			t.Fatal("wrong content type", ct)
+		}
+		var p Problem
+		err := json.NewDecoder(w.Body).Decode(&p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Status != code || p.Title != http.StatusText(code) {
+			t.Fatal("wrong problem", p)
+		}
+		return &p
+	}
+	routes := func(q url.Values) Routes {
+		w := api("GET", "/routers/"+DefaultRouter+"/routes", q, nil)
+		if w.Code != http.StatusOK {
+			t.Fatal("wrong status code", w.Code, w.Body.String())
+		}
+		var rs Routes
+		err := json.NewDecoder(w.Body).Decode(&rs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rs
+	}
+	routeQ := url.Values{"method": {"GET"}, "path": {"/api"}}
+	backendQ := func(addr string) url.Values {
+		return url.Values{"method": {"GET"}, "path": {"/api"}, "addr": {addr}}
+	}
+
+	w := api("GET", "/openapi.json", nil, nil)
+	if w.Code != http.StatusOK {
+		t.Fatal("wrong status code", w.Code)
+	}
+	var doc map[string]interface{}
+	err = json.NewDecoder(w.Body).Decode(&doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc["openapi"] == nil || doc["paths"] == nil {
+		t.Fatal("invalid document")
+	}
+
+	w = api("GET", "/routers", nil, nil)
+	var names []string
+	err = json.NewDecoder(w.Body).Decode(&names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != DefaultRouter {
+		t.Fatal("wrong routers", names)
+	}
+
+	// Routes.
+	w = api("POST", "/routers/"+DefaultRouter+"/routes", nil, &Route{Methode: "GET", Path: "/api", RedirTo: "http://10.0.0.1"})
+	if w.Code != http.StatusCreated {
+		t.Fatal("wrong status code", w.Code, w.Body.String())
+	}
+	if loc := w.Header().Get("Location"); loc != APIPrefix+"/routers/"+DefaultRouter+"/routes?"+routeQ.Encode() {
+		t.Fatal("wrong location", loc)
+	}
+	isProblem(api("POST", "/routers/"+DefaultRouter+"/routes", nil, &Route{Methode: "GET", Router: "other", Path: "/api"}), 422)
+	// The options of an existing route can't change.
+	isProblem(api("POST", "/routers/"+DefaultRouter+"/routes", nil, &Route{Methode: "GET", Path: "/api", RedirTo: "http://10.0.0.1", Timeout: time.Second}), http.StatusConflict)
+	w = api("POST", "/routers/"+DefaultRouter+"/routes", nil, &Route{Methode: "GET", Path: "/api", RedirTo: "http://10.0.0.1"})
+	if w.Code != http.StatusCreated {
+		t.Fatal("wrong status code", w.Code, w.Body.String())
+	}
+	if p := isProblem(api("POST", "/routers/"+DefaultRouter+"/routes", nil, "{"), http.StatusBadRequest); p.Detail == "" {
+		t.Fatal("no detail")
+	}
+
+	// Backends.
+	w = api("POST", "/routers/"+DefaultRouter+"/routes/backends", routeQ, &Backend{Addr: "http://10.0.0.2", Tag: "v2"})
+	if w.Code != http.StatusCreated {
+		t.Fatal("wrong status code", w.Code, w.Body.String())
+	}
+	isProblem(api("POST", "/routers/"+DefaultRouter+"/routes/backends", url.Values{"method": {"GET"}, "path": {"/nop"}}, &Backend{Addr: "http://10.0.0.2"}), http.StatusNotFound)
+	isProblem(api("POST", "/routers/"+DefaultRouter+"/routes/backends", nil, &Backend{Addr: "http://10.0.0.2"}), http.StatusBadRequest)
+	rs := routes(url.Values{"tag": {"v2"}})
+	if len(rs) != 1 || rs[0].Path != "/api" || len(rs[0].Backends) != 2 {
+		t.Fatal("wrong routes", rs)
+	}
+	if rs := routes(url.Values{"tag": {"v3"}}); len(rs) != 0 {
+		t.Fatal("wrong routes", rs)
+	}
+	// The control routes aren't listed.
+	if rs := routes(nil); len(rs) != 1 || rs[0].Path != "/api" {
+		t.Fatal("wrong routes", rs)
+	}
+	isProblem(api("GET", "/routers/nop/routes", nil, nil), http.StatusNotFound)
+
+	weight := 3
+	w = api("PATCH", "/routers/"+DefaultRouter+"/routes/backends", backendQ("http://10.0.0.2"), &BackendPatch{Weight: &weight})
+	if w.Code != http.StatusNoContent {
+		t.Fatal("wrong status code", w.Code, w.Body.String())
+	}
+	for _, b := range routes(routeQ)[0].Backends {
+		if b.Addr == "http://10.0.0.2" && b.Weight != 3 {
+			t.Fatal("wrong weight", b.Weight)
+		}
+	}
+	draining := true
+	w = api("PATCH", "/routers/"+DefaultRouter+"/routes/backends", backendQ("http://10.0.0.2"), &BackendPatch{Draining: &draining})
+	if w.Code != http.StatusNoContent {
+		t.Fatal("wrong status code", w.Code, w.Body.String())
+	}
+	draining = false
+	isProblem(api("PATCH", "/routers/"+DefaultRouter+"/routes/backends", backendQ("http://10.0.0.2"), &BackendPatch{Draining: &draining}), 422)
+	isProblem(api("PATCH", "/routers/"+DefaultRouter+"/routes/backends", backendQ("http://10.0.0.9"), &BackendPatch{Weight: &weight}), http.StatusNotFound)
+	isProblem(api("PATCH", "/routers/"+DefaultRouter+"/routes/backends", routeQ, &BackendPatch{Weight: &weight}), http.StatusBadRequest)
+	w = api("DELETE", "/routers/"+DefaultRouter+"/routes/backends", backendQ("http://10.0.0.2"), nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatal("wrong status code", w.Code, w.Body.String())
+	}
+	if bs := routes(routeQ)[0].Backends; len(bs) != 1 {
+		t.Fatal("wrong backends", bs)
+	}
+
+	// Split and rules.
+	w = api("PUT", "/routers/"+DefaultRouter+"/routes/split", routeQ, &SplitPolicy{Weights: map[string]int{"": 1}})
+	if w.Code != http.StatusNoContent {
+		t.Fatal("wrong status code", w.Code, w.Body.String())
+	}
+	isProblem(api("PUT", "/routers/"+DefaultRouter+"/routes/split", routeQ, &SplitPolicy{Weights: map[string]int{"": -1}}), 422)
+	w = api("PUT", "/routers/"+DefaultRouter+"/routes/rules", routeQ, []*Rule{{Tag: "v2"}})
+	if w.Code != http.StatusNoContent {
+		t.Fatal("wrong status code", w.Code, w.Body.String())
+	}
+	if rt := routes(routeQ)[0]; rt.Split == nil || len(rt.Rules) != 1 {
+		t.Fatal("wrong route", rt.Split, rt.Rules)
+	}
+
+	// Unknown resources and methods.
+	isProblem(api("GET", "/nop", nil, nil), http.StatusNotFound)
+	w = api("PUT", "/routers/"+DefaultRouter+"/routes", nil, nil)
+	isProblem(w, http.StatusMethodNotAllowed)
+	if allow := w.Header().Get("Allow"); allow != "DELETE, GET, POST" {
+		t.Fatal("wrong allow", allow)
+	}
+
+	// Delete the route.
+	w = api("DELETE", "/routers/"+DefaultRouter+"/routes", routeQ, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatal("wrong status code", w.Code, w.Body.String())
+	}
+	if rs := routes(routeQ); len(rs) != 0 {
+		t.Fatal("wrong routes", rs)
+	}
+	isProblem(api("DELETE", "/routers/"+DefaultRouter+"/routes", routeQ, nil), http.StatusNotFound)
+	// A deleted route is created again with the new options.
+	w = api("POST", "/routers/"+DefaultRouter+"/routes", nil, &Route{Methode: "GET", Path: "/api", RedirTo: "http://10.0.0.1", Timeout: time.Second})
+	if w.Code != http.StatusCreated {
+		t.Fatal("wrong status code", w.Code, w.Body.String())
+	}
+	if rs := routes(routeQ); len(rs) != 1 || rs[0].Timeout != time.Second || rs[0].Split != nil || len(rs[0].Rules) != 0 {
+		t.Fatal("wrong routes", rs)
+	}
+
+	// Only the loopback clients without authentication.
+	req := httptest.NewRequest("GET", "http://localhost"+APIPrefix+"/routers", nil)
+	req.Header.Set("X-Real-Ip", "10.0.0.1")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	isProblem(w, http.StatusForbidden)
+}
@@ -0,0 +1,206 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/fcavani/e"
+	fhttp "github.com/fcavani/http"
+	log "github.com/fcavani/slog"
+)
+
+const ctxIdentity string = "controlidentity"
+
+// Identity is a client of the control API.
+type Identity struct {
+	// Name of the client, used in the logs.
+	Name string
+	// Token is the bearer token of the client, only accepted in TLS
+	// connections.
+	Token string
+	// Subject is the common name of the client certificate. The certificate
+	// must be verified by the server, see ClientAuth in the http package.
+	// With Token and Subject the client needs both.
+	Subject string
+	// Routers are the names of the routers the client can change. Empty
+	// allows all.
+	Routers []string
+	// Prefixes are the path prefixes the client can change. Empty allows all.
+	Prefixes []string
+}
+
+// allowed returns true if the identity can change the path of the router.
+func (id *Identity) allowed(routerName, path string) bool {
+	return id.allowedRouter(routerName) && id.allowedPath(path)
+}
+
+func (id *Identity) allowedRouter(routerName string) bool {
+	if id == nil || len(id.Routers) == 0 {
+		return true
+	}
+	for _, name := range id.Routers {
+		if name == routerName {
+			return true
+		}
+	}
+	return false
+}
+
+func (id *Identity) allowedPath(path string) bool {
+	if id == nil || len(id.Prefixes) == 0 {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+	for _, prefix := range id.Prefixes {
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ControlAuth configures the authentication of the clients of the control
+// API, the /_router endpoints.
+type ControlAuth struct {
+	// Identities are the clients allowed.
+	Identities []*Identity
+	// Loopback allows the loopback clients without credentials, with access
+	// to all routes.
+	Loopback bool
+	// AdminOnly disables the control API in the routers, it only answers in
+	// the handler returned by ControlHandler.
+	AdminOnly bool
+}
+
+// authenticate returns the identity of the client, nil for a loopback client.
+// The tokens aren't sent in plain text.
+func (ca *ControlAuth) authenticate(req *http.Request) (*Identity, error) {
+	var token string
+	if req.TLS != nil {
+		token = bearer(req)
+	}
+	subject := peerSubject(req)
+	for _, id := range ca.Identities {
+		if id.Token == "" && id.Subject == "" {
+			continue
+		}
+		if id.Token != "" && subtle.ConstantTimeCompare([]byte(id.Token), []byte(token)) != 1 {
+			continue
+		}
+		if id.Subject != "" && id.Subject != subject {
+			continue
+		}
+		return id, nil
+	}
+	if ca.Loopback && loopback(req) {
+		return nil, nil
+	}
+	return nil, e.New("invalid credentials")
+}
+
+// bearer returns the bearer token of the request.
+func bearer(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[7:])
+}
+
+// peerSubject returns the common name of the verified client certificate.
+func peerSubject(req *http.Request) string {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return req.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
+func loopback(req *http.Request) bool {
+	ipstr, err := fhttp.RemoteIP(req)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(ipstr)
+	return ip != nil && ip.IsLoopback()
+}
+
+// SetControlAuth sets the authentication of the control API. Nil, the
+// default, only allows the loopback clients.
+func (r *Router) SetControlAuth(auth *ControlAuth) {
+	r.authLck.Lock()
+	defer r.authLck.Unlock()
+	r.auth = auth
+}
+
+func (r *Router) controlAuth() *ControlAuth {
+	r.authLck.RLock()
+	defer r.authLck.RUnlock()
+	return r.auth
+}
+
+// ControlHandler returns the handler of the control API, to be served in a
+// separate address. Call it after Start.
+func (r *Router) ControlHandler() http.Handler {
+	return r.apiSwitch(true, r.admin)
+}
+
+// failFunc writes the error err with the status code to the client.
+type failFunc func(w http.ResponseWriter, code int, err error)
+
+// control authenticates the clients of the control API. admin is true for the
+// handler of ControlHandler. fail writes the errors.
+func (r *Router) control(admin bool, fail failFunc, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		auth := r.controlAuth()
+		if auth == nil {
+			if !loopback(req) {
+				fail(w, http.StatusForbidden, e.New("ip isn't loopback"))
+				return
+			}
+			f(w, req)
+			return
+		}
+		if auth.AdminOnly && !admin {
+			fail(w, http.StatusNotFound, e.New("not found"))
+			return
+		}
+		id, err := auth.authenticate(req)
+		if err != nil {
+			log.Tag("router", "control").Errorf("Control API access denied for %v: %v", req.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="droute"`)
+			fail(w, http.StatusUnauthorized, err)
+			return
+		}
+		if id != nil {
+			req = req.WithContext(context.WithValue(req.Context(), ctxIdentity, id))
+		}
+		f(w, req)
+	}
+}
+
+// identity returns the identity of the client of the control API, nil if it
+// has access to all routes.
+func identity(req *http.Request) *Identity {
+	id, _ := req.Context().Value(ctxIdentity).(*Identity)
+	return id
+}
+
+// authorize returns an error if the client can't change the path of the
+// router.
+func authorize(req *http.Request, routerName, path string) error {
+	id := identity(req)
+	if id.allowed(routerName, path) {
+		return nil
+	}
+	log.Tag("router", "control").Errorf("%v can't change (%v, %v)", id.Name, routerName, path)
+	return e.New("forbidden")
+}
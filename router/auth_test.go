@@ -0,0 +1,182 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+func TestIdentityAllowed(t *testing.T) {
+	id := &Identity{
+		Routers:  []string{DefaultRouter},
+		Prefixes: []string{"/api", "/static/"},
+	}
+	tests := []struct {
+		id     *Identity
+		router string
+		path   string
+		result bool
+	}{
+		{nil, "any", "/any", true},
+		{&Identity{}, "any", "/any", true},
+		{id, DefaultRouter, "/api", true},
+		{id, DefaultRouter, "/api/users/:id", true},
+		{id, DefaultRouter, "/apis", false},
+		{id, DefaultRouter, "/static/file", true},
+		{id, DefaultRouter, "/static", false},
+		{id, DefaultRouter, "", false},
+		{id, "other", "/api", false},
+	}
+	for i, test := range tests {
+		if r := test.id.allowed(test.router, test.path); r != test.result {
+			t.Fatal(i, "wrong result", r)
+		}
+	}
+}
+
+func loadCert(t *testing.T, file string) *x509.Certificate {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		t.Fatal("no certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestControlAuth(t *testing.T) {
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	r.SetControlAuth(&ControlAuth{
+		Identities: []*Identity{
+			{
+				Name:     "token",
+				Token:    "secret",
+				Routers:  []string{DefaultRouter},
+				Prefixes: []string{"/api"},
+			},
+			{
+				Name:    "cert",
+				Subject: "localhost",
+			},
+		},
+		AdminOnly: true,
+	})
+
+	do := func(h http.Handler, method, path, token string, tlsState *tls.ConnectionState, route *Route) *responsewriter.ResponseWriter {
+		buf, err := json.Marshal(route)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest(method, "http://localhost/en"+path, bytes.NewBuffer(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Add("X-Real-Ip", "10.0.0.1")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.TLS = tlsState
+		rw := responsewriter.NewResponseWriter()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	secure := &tls.ConnectionState{}
+	api := &Route{Methode: "GET", Router: DefaultRouter, Path: "/api/users", RedirTo: "http://10.0.0.2"}
+	other := &Route{Methode: "GET", Router: DefaultRouter, Path: "/other", RedirTo: "http://10.0.0.2"}
+	admin := r.ControlHandler()
+
+	// Only in the admin handler.
+	if code := do(r, "POST", "/_router/add", "secret", nil, api).ResponseCode(); code != http.StatusNotFound {
+		t.Fatal("wrong response code", code)
+	}
+	rw := do(admin, "POST", "/_router/add", "", nil, api)
+	if code := rw.ResponseCode(); code != http.StatusUnauthorized {
+		t.Fatal("wrong response code", code)
+	}
+	if rw.Header().Get("WWW-Authenticate") == "" {
+		t.Fatal("no WWW-Authenticate header")
+	}
+	if code := do(admin, "POST", "/_router/add", "wrong", secure, api).ResponseCode(); code != http.StatusUnauthorized {
+		t.Fatal("wrong response code", code)
+	}
+	if code := do(admin, "POST", "/_router/add", "secret", secure, api).ResponseCode(); code != http.StatusCreated {
+		t.Fatal("wrong response code", code)
+	}
+	if code := do(admin, "POST", "/_router/add", "secret", secure, other).ResponseCode(); code != http.StatusForbidden {
+		t.Fatal("wrong response code", code)
+	}
+	// The token in plain text.
+	if code := do(admin, "POST", "/_router/add", "secret", nil, api).ResponseCode(); code != http.StatusUnauthorized {
+		t.Fatal("wrong response code", code)
+	}
+
+	// A verified client certificate.
+	state := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{loadCert(t, "../device.crt")}},
+	}
+	if code := do(admin, "POST", "/_router/add", "", state, other).ResponseCode(); code != http.StatusCreated {
+		t.Fatal("wrong response code", code)
+	}
+	// Not verified.
+	state = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{loadCert(t, "../device.crt")},
+	}
+	if code := do(admin, "POST", "/_router/add", "", state, other).ResponseCode(); code != http.StatusUnauthorized {
+		t.Fatal("wrong response code", code)
+	}
+
+	// The token only sees its routes.
+	rw = do(admin, "GET", "/_router/get", "secret", secure, &Route{Router: DefaultRouter})
+	if code := rw.ResponseCode(); code != http.StatusOK {
+		t.Fatal("wrong response code", code)
+	}
+	var resp ResponseRoutes
+	err = json.NewDecoder(rw).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Routes.Search("/api/users") || resp.Routes.Search("/other") {
+		t.Fatal("wrong routes", resp.Routes)
+	}
+	if code := do(admin, "GET", "/_router/get", "secret", secure, &Route{Router: "redir"}).ResponseCode(); code != http.StatusForbidden {
+		t.Fatal("wrong response code", code)
+	}
+
+	// Loopback.
+	r.SetControlAuth(&ControlAuth{Loopback: true})
+	req, err := http.NewRequest("GET", "http://localhost/en/_router/get", bytes.NewBufferString(`{"Router":"_def_"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("X-Real-Ip", "127.0.0.1")
+	rw = responsewriter.NewResponseWriter()
+	r.ServeHTTP(rw, req)
+	if code := rw.ResponseCode(); code != http.StatusOK {
+		t.Fatal("wrong response code", code)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/boltdb/bolt"
+	"github.com/fcavani/e"
+)
+
+var bRoutes = []byte("bRoutes")
+
+// BoltStore is a RouteStore in a BoltDB database. The routes are json encoded
+// with the router, method and path as key.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens or creates the database in filename.
+func OpenBoltStore(filename string, perm os.FileMode, opt *bolt.Options) (*BoltStore, error) {
+	db, err := bolt.Open(filename, perm, opt)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, er := tx.CreateBucketIfNotExists(bRoutes)
+		if er != nil {
+			return e.New(er)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, e.Forward(err)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func storeKey(routerName, method, path string) []byte {
+	return []byte(strings.Join([]string{routerName, method, path}, "\x00"))
+}
+
+// Put stores the route.
+func (s *BoltStore) Put(route *Route) error {
+	buf, err := json.Marshal(route)
+	if err != nil {
+		return e.Push(err, "can't encode the route")
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		er := tx.Bucket(bRoutes).Put(storeKey(route.Router, route.Methode, route.Path), buf)
+		if er != nil {
+			return e.New(er)
+		}
+		return nil
+	})
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
+
+// Del removes the route. It isn't an error if the route isn't in the store.
+func (s *BoltStore) Del(routerName, method, path string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		er := tx.Bucket(bRoutes).Delete(storeKey(routerName, method, path))
+		if er != nil {
+			return e.New(er)
+		}
+		return nil
+	})
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
+
+// Routes returns all routes in the store sorted by router, method and path.
+func (s *BoltStore) Routes() (Routes, error) {
+	routes := make(Routes, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bRoutes).ForEach(func(k, v []byte) error {
+			var route Route
+			er := json.Unmarshal(v, &route)
+			if er != nil {
+				return e.Push(er, e.New("can't decode the route %q", k))
+			}
+			routes = append(routes, &route)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return routes, nil
+}
+
+// Close the database.
+func (s *BoltStore) Close() error {
+	err := s.db.Close()
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
@@ -6,6 +6,8 @@ package router
 
 import (
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/fcavani/droute/errhandler"
 	"github.com/fcavani/droute/responsewriter"
@@ -15,36 +17,220 @@ import (
 	"github.com/sony/gobreaker"
 )
 
-// Cbs stores the servers subject of the circuit braker
-type Cbs map[string]*gobreaker.CircuitBreaker
+// BreakerSettings configures the circuit breakers. The breaker trips when one
+// of the conditions is true. The failures are the server errors, see
+// serverError, and the slow responses.
+type BreakerSettings struct {
+	// ConsecutiveFailures trips the breaker after this number of consecutive
+	// failures. Defaults to 5 if FailureRatio is zero.
+	ConsecutiveFailures uint32
+	// FailureRatio trips the breaker if the ratio of failures in Interval is
+	// greater or equal to it, after at least MinRequests requests.
+	FailureRatio float64
+	MinRequests  uint32
+	// Interval is the window of the counts when the breaker is closed. Zero
+	// never clears the counts.
+	Interval time.Duration
+	// Latency, if not zero, counts the slower responses as failures.
+	Latency time.Duration
+	// Timeout is the time the breaker stays open before it lets the probe
+	// requests pass. Defaults to 60s.
+	Timeout time.Duration
+	// MaxRequests is the number of probe requests allowed when half-open.
+	// Defaults to 1.
+	MaxRequests uint32
+	// PerRoute uses one breaker per server in each route instead of one per
+	// server.
+	PerRoute bool
+	// OnStateChange is called when the state of the breaker of the server dst
+	// changes.
+	OnStateChange func(dst string, from, to gobreaker.State)
+}
+
+func (bs BreakerSettings) withDefaults() *BreakerSettings {
+	if bs.ConsecutiveFailures == 0 && bs.FailureRatio <= 0 {
+		bs.ConsecutiveFailures = 5
+	}
+	if bs.Timeout <= 0 {
+		bs.Timeout = 60 * time.Second
+	}
+	if bs.MaxRequests == 0 {
+		bs.MaxRequests = 1
+	}
+	return &bs
+}
+
+func (bs *BreakerSettings) readyToTrip(counts gobreaker.Counts) bool {
+	if bs.ConsecutiveFailures > 0 && counts.ConsecutiveFailures >= bs.ConsecutiveFailures {
+		return true
+	}
+	if bs.FailureRatio > 0 && counts.Requests > 0 && counts.Requests >= bs.MinRequests {
+		return float64(counts.TotalFailures)/float64(counts.Requests) >= bs.FailureRatio
+	}
+	return false
+}
+
+// Cbs stores the servers subject of the circuit braker. It is safe for
+// concurrent use.
+type Cbs struct {
+	st     *BreakerSettings
+	cbs    map[string]*gobreaker.CircuitBreaker
+	opened map[string]time.Time
+	lck    sync.Mutex
+	// notify is called when the server dst must leave or come back to the
+	// LoadBalance.
+	notify func(dst string, open bool)
+}
+
+// NewCbs creates a circuit breaker registry. st can be nil for the defaults.
+func NewCbs(st *BreakerSettings) *Cbs {
+	if st == nil {
+		st = &BreakerSettings{}
+	}
+	return &Cbs{
+		st:     st.withDefaults(),
+		cbs:    make(map[string]*gobreaker.CircuitBreaker),
+		opened: make(map[string]time.Time),
+	}
+}
+
+// Get returns the circuit breaker of the server dst, creating it if needed.
+func (c *Cbs) Get(dst string) *gobreaker.CircuitBreaker {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	cb, found := c.cbs[dst]
+	if found {
+		return cb
+	}
+	cb = gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:          dst,
+		MaxRequests:   c.st.MaxRequests,
+		Interval:      c.st.Interval,
+		Timeout:       c.st.Timeout,
+		ReadyToTrip:   c.st.readyToTrip,
+		OnStateChange: c.stateChange,
+	})
+	c.cbs[dst] = cb
+	return cb
+}
+
+// isOpen returns true if the breaker of dst is open and the server is out of
+// the LoadBalance.
+func (c *Cbs) isOpen(dst string) bool {
+	if c == nil {
+		return false
+	}
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	_, found := c.opened[dst]
+	return found
+}
+
+// stateChange is called by gobreaker with the breaker locked, so
+// OnStateChange and notify run in other goroutines, the requests to the server
+// would wait for them.
+func (c *Cbs) stateChange(dst string, from, to gobreaker.State) {
+	log.Tag("router", "circuitbrake").Printf("Circuit breaker of %v: %v => %v", dst, from, to)
+	if c.st.OnStateChange != nil {
+		go c.st.OnStateChange(dst, from, to)
+	}
+	if to != gobreaker.StateOpen {
+		return
+	}
+	now := time.Now()
+	c.lck.Lock()
+	c.opened[dst] = now
+	c.lck.Unlock()
+	if c.notify != nil {
+		go func() {
+			// The server may be back before the goroutine runs.
+			if c.isOpen(dst) {
+				c.notify(dst, true)
+			}
+		}()
+	}
+	// Without requests the breaker never goes to half-open, so the server is
+	// put back in the LoadBalance when the timeout ends to receive the probes.
+	time.AfterFunc(c.st.Timeout, func() {
+		c.lck.Lock()
+		t, found := c.opened[dst]
+		if !found || !t.Equal(now) {
+			c.lck.Unlock()
+			return
+		}
+		delete(c.opened, dst)
+		c.lck.Unlock()
+		if c.notify != nil {
+			c.notify(dst, false)
+		}
+	})
+}
 
 // CircuitBrake brakes the connection from the proxy to the server if the server
-// dies.
-func CircuitBrake(cbs Cbs, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+// dies. The servers with the breaker open receive a 503 without being
+// contacted.
+func CircuitBrake(cbs *Cbs, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
 	return func(rw *responsewriter.ResponseWriter, req *http.Request) {
 		proxy := req.Context().Value("proxyredirdst").(string)
-		cb, found := cbs[proxy]
-		if !found {
-			st := gobreaker.Settings{
-				Name: proxy,
-			}
-			cb = gobreaker.NewCircuitBreaker(st)
-			cbs[proxy] = cb
-		}
+		cb := cbs.Get(proxy)
 		_, err := cb.Execute(func() (interface{}, error) {
+			start := time.Now()
 			handler(rw, req)
 			code := rw.ResponseCode()
-			// TODO: 500 is for server error not fatal...
-			if code > 500 && code < 600 {
+			if serverError(code) {
 				return nil, e.New("server fail")
 			}
+			if cbs.st.Latency > 0 && time.Since(start) > cbs.st.Latency {
+				return nil, e.New("server too slow")
+			}
 			return nil, nil
 		})
-		if err != nil {
-			delete(cbs, proxy)
-			errhandler.ErrHandler(rw, http.StatusInternalServerError, e.Forward(err))
-			log.DebugLevel().Tag("router", "circuitbrake").Println(err)
+		if err == gobreaker.ErrOpenState || err == gobreaker.ErrTooManyRequests {
+			log.DebugLevel().Tag("router", "circuitbrake").Println(proxy, err)
+			errhandler.ErrHandler(rw, http.StatusServiceUnavailable, e.Forward(err))
 			return
 		}
 	}
 }
+
+// SetBreakerSettings configures the circuit breakers of the routes added after
+// the call.
+func (r *Router) SetBreakerSettings(st *BreakerSettings) {
+	r.breaker = st
+	r.cbs = r.newCbs(st)
+}
+
+// newCbs creates a registry that removes the servers from the LoadBalance of
+// its routes while the breaker is open.
+func (r *Router) newCbs(st *BreakerSettings) *Cbs {
+	c := NewCbs(st)
+	c.notify = func(dst string, open bool) {
+		r.tableLck.Lock()
+		defer r.tableLck.Unlock()
+		for key, entry := range r.table {
+			if entry.cbs != c || !entry.active {
+				continue
+			}
+			b := entry.get(dst)
+			if b == nil {
+				continue
+			}
+			if open {
+				entry.remove(key, b)
+			} else if r.available(entry, dst) {
+				r.lbAdd(entry, key, b)
+			}
+		}
+	}
+	return c
+}
+
+// available returns true if the server dst can receive requests. tableLck must
+// be locked.
+func (r *Router) available(entry *routeEntry, dst string) bool {
+	if b := entry.get(dst); b != nil && !b.draining.IsZero() {
+		return false
+	}
+	health := r.healthOf(dst)
+	return health != HealthDown && health != HealthUnverified && !entry.cbs.isOpen(dst)
+}
@@ -5,17 +5,21 @@
 package router
 
 import (
+	"context"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/fcavani/droute/responsewriter"
+	"github.com/sony/gobreaker"
 )
 
 func TestBrake(t *testing.T) {
 	dst := "10.0.1.1"
 	rr := NewRoundRobin()
 	rr.AddAddrs("GET", "/", dst)
-	cbs := make(Cbs)
+	cbs := NewCbs(&BreakerSettings{ConsecutiveFailures: 2})
 	rw := responsewriter.NewResponseWriter()
 	r, err := http.NewRequest("GET", "http://localhost/", nil)
 	if err != nil {
@@ -31,24 +35,173 @@ func TestBrake(t *testing.T) {
 	if code := rw.ResponseCode(); code != 200 {
 		t.Fatal("wrong response code", code)
 	}
-	cb, found := cbs[dst]
-	if !found {
-		t.Fatal("circuit brake for this server not found", dst)
+	cb := cbs.Get(dst)
+	if s := cb.State(); s != gobreaker.StateClosed {
+		t.Fatal("wrong state", s)
 	}
-	s := cb.State()
-	t.Log(s)
 
-	h = Balance(rr, CircuitBrake(cbs, func(rw *responsewriter.ResponseWriter, r *http.Request) {
-		proxy := r.Context().Value("proxyredirdst").(string)
-		t.Log(proxy)
-		rw.WriteHeader(501)
-	}))
+	calls := 0
+	h = CircuitBrake(cbs, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		calls++
+		rw.WriteHeader(502)
+	})
+	r = r.WithContext(contextWithDst(r, dst))
+	for i := 0; i < 2; i++ {
+		rw = responsewriter.NewResponseWriter()
+		h(rw, r)
+		// The server response isn't changed.
+		if code := rw.ResponseCode(); code != 502 {
+			t.Fatal("wrong response code", code)
+		}
+	}
+	if cbs.Get(dst) != cb {
+		t.Fatal("breaker replaced")
+	}
+	if s := cb.State(); s != gobreaker.StateOpen {
+		t.Fatal("wrong state", s)
+	}
+	rw = responsewriter.NewResponseWriter()
+	h(rw, r)
+	if code := rw.ResponseCode(); code != http.StatusServiceUnavailable {
+		t.Fatal("wrong response code", code)
+	}
+	if calls != 2 {
+		t.Fatal("server called with the breaker open", calls)
+	}
+}
+
+func TestBrakeConnectError(t *testing.T) {
+	// The server is gone, Proxy answers 500.
+	server := httptest.NewServer(http.NotFoundHandler())
+	dst := server.URL
+	server.Close()
+	cbs := NewCbs(&BreakerSettings{ConsecutiveFailures: 1})
+	h := CircuitBrake(cbs, Proxy("/", time.Second))
+	r, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(contextWithDst(r, dst))
+	rw := responsewriter.NewResponseWriter()
+	h(rw, r)
+	if code := rw.ResponseCode(); code != http.StatusInternalServerError {
+		t.Fatal("wrong response code", code)
+	}
+	if s := cbs.Get(dst).State(); s != gobreaker.StateOpen {
+		t.Fatal("wrong state", s)
+	}
+}
+
+func TestBrakeTrip(t *testing.T) {
+	bs := (&BreakerSettings{FailureRatio: 0.5, MinRequests: 4}).withDefaults()
+	if bs.ConsecutiveFailures != 0 {
+		t.Fatal("consecutive failures set", bs.ConsecutiveFailures)
+	}
+	if bs.readyToTrip(gobreaker.Counts{Requests: 3, TotalFailures: 3}) {
+		t.Fatal("tripped before min requests")
+	}
+	if bs.readyToTrip(gobreaker.Counts{Requests: 4, TotalFailures: 1}) {
+		t.Fatal("tripped below the ratio")
+	}
+	if !bs.readyToTrip(gobreaker.Counts{Requests: 4, TotalFailures: 2}) {
+		t.Fatal("not tripped")
+	}
+
+	bs = (&BreakerSettings{}).withDefaults()
+	if bs.readyToTrip(gobreaker.Counts{ConsecutiveFailures: 4}) {
+		t.Fatal("tripped before the failures")
+	}
+	if !bs.readyToTrip(gobreaker.Counts{ConsecutiveFailures: 5}) {
+		t.Fatal("not tripped")
+	}
+}
+
+func TestBrakeLatency(t *testing.T) {
+	dst := "10.0.1.2"
+	changes := make(chan gobreaker.State, 10)
+	cbs := NewCbs(&BreakerSettings{
+		ConsecutiveFailures: 1,
+		Latency:             10 * time.Millisecond,
+		OnStateChange: func(name string, from, to gobreaker.State) {
+			changes <- to
+		},
+	})
+	h := CircuitBrake(cbs, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		rw.WriteHeader(200)
+	})
+	r, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = r.WithContext(contextWithDst(r, dst))
+	rw := responsewriter.NewResponseWriter()
 	h(rw, r)
-	if code := rw.ResponseCode(); code != 500 {
+	if code := rw.ResponseCode(); code != 200 {
 		t.Fatal("wrong response code", code)
 	}
-	_, found = cbs[dst]
-	if found {
-		t.Fatal("circuit brake for this server found", dst)
+	select {
+	case s := <-changes:
+		if s != gobreaker.StateOpen {
+			t.Fatal("wrong state", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("breaker not open")
+	}
+}
+
+func TestRouterBreaker(t *testing.T) {
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	r.SetBreakerSettings(&BreakerSettings{ConsecutiveFailures: 1, Timeout: 100 * time.Millisecond})
+
+	dst := "http://10.0.1.3"
+	err = r.Add(DefaultRouter, "GET", "/brake", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Add(DefaultRouter, "GET", "/brake", "http://10.0.1.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := routeLB(r, DefaultRouter, "GET", "/brake")
+
+	h := CircuitBrake(r.cbs, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(502)
+	})
+	req, err := http.NewRequest("GET", "http://localhost/brake", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(responsewriter.NewResponseWriter(), req.WithContext(contextWithDst(req, dst)))
+
+	// The server is out of the LoadBalance while the breaker is open, it is
+	// removed in another goroutine.
+	for i := 0; contains(lb, "GET", "/brake", dst); i++ {
+		if i == 50 {
+			t.Fatal("server not removed")
+		}
+		time.Sleep(time.Millisecond)
 	}
+	err = r.Add(DefaultRouter, "GET", "/brake", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains(lb, "GET", "/brake", dst) {
+		t.Fatal("server with breaker open added")
+	}
+
+	// And back for the probes after the timeout.
+	time.Sleep(300 * time.Millisecond)
+	if !contains(lb, "GET", "/brake", dst) {
+		t.Fatal("server not added again")
+	}
+}
+
+func contextWithDst(r *http.Request, dst string) context.Context {
+	return context.WithValue(r.Context(), ctxName, dst)
 }
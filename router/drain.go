@@ -0,0 +1,99 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+	"github.com/fcavani/e"
+	log "github.com/fcavani/slog"
+)
+
+// DrainTimeout is the max time a draining server waits for the requests in
+// flight before it is removed.
+var DrainTimeout = 5 * time.Minute
+
+// Drain stops sending new requests to the server dst. The server is removed
+// from the route when the requests in flight finish or after DrainTimeout.
+// Adding the server again stops the drain.
+func (r *Router) Drain(routerName, method, path, dst string) (err error) {
+	defer func() {
+		if err != nil {
+			log.Errorf("Can't drain (%v, %v, %v => %v) error: %v", routerName, method, path, dst, err)
+			return
+		}
+		log.DebugLevel().Printf("Draining (%v, %v, %v => %v).", routerName, method, path, dst)
+	}()
+	path, err = checkRoute(routerName, method, path, dst)
+	if err != nil {
+		return
+	}
+
+	key := routeKey{router: routerName, method: method, path: path}
+
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+
+	entry, found := r.table[key]
+	if !found || !entry.active {
+		err = e.New("route not found")
+		return
+	}
+	b := entry.get(dst)
+	if b == nil {
+		err = e.New("destiny not found")
+		return
+	}
+	if b.draining.IsZero() {
+		b.draining = time.Now().Add(DrainTimeout)
+	}
+	entry.remove(key, b)
+	r.save(key, entry)
+	return
+}
+
+// inflight counts the requests in flight of the servers of the route. The
+// request ends when the body of the response is closed.
+func (r *Router) inflight(key routeKey, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	return func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		dst, _ := req.Context().Value(ctxName).(string)
+		var b *backend
+		r.tableLck.RLock()
+		if entry, found := r.table[key]; found {
+			b = entry.get(dst)
+		}
+		r.tableLck.RUnlock()
+		if b == nil {
+			handler(rw, req)
+			return
+		}
+		atomic.AddInt32(&b.inflight, 1)
+		handler(rw, req)
+		if body := rw.TakeBody(); body != nil {
+			rw.SetBody(&inflightBody{ReadCloser: body, b: b})
+			return
+		}
+		atomic.AddInt32(&b.inflight, -1)
+	}
+}
+
+// inflightBody ends the request in flight when closed.
+type inflightBody struct {
+	io.ReadCloser
+	b    *backend
+	once sync.Once
+}
+
+func (ib *inflightBody) Close() error {
+	ib.once.Do(func() {
+		atomic.AddInt32(&ib.b.inflight, -1)
+	})
+	return ib.ReadCloser.Close()
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestDrain(t *testing.T) {
+	HTTPClient = http.DefaultClient
+	arrived := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		w.Write([]byte("done"))
+	}))
+	defer server.Close()
+
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	err = r.Add(DefaultRouter, "GET", "/drain", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := routeLB(r, DefaultRouter, "GET", "/drain")
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost/en/drain", nil)
+		r.ServeHTTP(w, req)
+		done <- w
+	}()
+	<-arrived
+
+	err = r.Drain(DefaultRouter, "GET", "/drain", "http://10.0.0.9")
+	if err != nil && !e.Contains(err, "destiny not found") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+	err = r.Drain(DefaultRouter, "GET", "/drain", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains(lb, "GET", "/drain", server.URL) {
+		t.Fatal("draining server still in the load balance")
+	}
+
+	// The request in flight holds the server.
+	r.reap(time.Now())
+	routes, err := r.Get(DefaultRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	draining := false
+	for _, route := range routes {
+		for _, b := range route.Backends {
+			if b.Addr == server.URL {
+				draining = b.Draining
+			}
+		}
+	}
+	if !draining {
+		t.Fatal("server not draining")
+	}
+
+	close(release)
+	w := <-done
+	if w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Fatal("request in flight failed", w.Code, w.Body.String())
+	}
+
+	r.reap(time.Now())
+	if r.active(routeKey{router: DefaultRouter, method: "GET", path: "/drain"}) {
+		t.Fatal("drained server not removed")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	dst := "http://10.0.0.1"
+	err = r.Add(DefaultRouter, "GET", "/draint", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := routeLB(r, DefaultRouter, "GET", "/draint")
+	key := routeKey{router: DefaultRouter, method: "GET", path: "/draint"}
+	b := r.table[key].get(dst)
+	b.inflight = 1
+
+	err = r.Drain(DefaultRouter, "GET", "/draint", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Adding again stops the drain.
+	err = r.Add(DefaultRouter, "GET", "/draint", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !contains(lb, "GET", "/draint", dst) {
+		t.Fatal("server not added again")
+	}
+	r.reap(time.Now().Add(2 * DrainTimeout))
+	if !r.active(key) {
+		t.Fatal("server removed")
+	}
+
+	err = r.Drain(DefaultRouter, "GET", "/draint", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.reap(time.Now())
+	if !r.active(key) {
+		t.Fatal("server removed with requests in flight")
+	}
+	r.reap(time.Now().Add(2 * DrainTimeout))
+	if r.active(key) {
+		t.Fatal("server not removed after the timeout")
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+// ViaName is the pseudonym of the proxy in the Via header.
+var ViaName = "droute"
+
+// hopHeaders are the headers that are only valid for one connection and
+// must not be forwarded, RFC 7230 section 6.1.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// removeHopHeaders removes the hop-by-hop headers and the headers listed in
+// the Connection header.
+func removeHopHeaders(h http.Header) {
+	for _, val := range h["Connection"] {
+		for _, key := range strings.Split(val, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				h.Del(key)
+			}
+		}
+	}
+	for _, key := range hopHeaders {
+		h.Del(key)
+	}
+}
+
+// cleanRequestHeaders removes the hop-by-hop headers from the request sent to
+// the server. The upgrade headers are kept if the request is an upgrade.
+func cleanRequestHeaders(h http.Header) {
+	upgrade := ""
+	if headerContains(h, "Connection", "upgrade") {
+		upgrade = h.Get("Upgrade")
+	}
+	trailers := headerContains(h, "Te", "trailers")
+	removeHopHeaders(h)
+	if trailers {
+		h.Set("Te", "trailers")
+	}
+	if upgrade != "" {
+		h.Set("Connection", "Upgrade")
+		h.Set("Upgrade", upgrade)
+	}
+}
+
+// Forwarded adds to the request the headers that tell the server who is the
+// client: X-Forwarded-For, X-Forwarded-Proto, X-Forwarded-Host, Forwarded
+// (RFC 7239) and Via. If trust returns false the forwarding headers sent by
+// the client are discarded, if true the proxy appends its information to
+// them.
+func Forwarded(trust func() bool, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	return func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		forwardHeaders(req, trust())
+		handler(rw, req)
+		rw.Header().Add("Via", via(req))
+	}
+}
+
+func forwardHeaders(req *http.Request, trust bool) {
+	h := req.Header
+	if !trust {
+		h.Del("X-Forwarded-For")
+		h.Del("X-Forwarded-Proto")
+		h.Del("X-Forwarded-Host")
+		h.Del("Forwarded")
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+	if ip != "" {
+		if prior := h.Get("X-Forwarded-For"); prior != "" {
+			h.Set("X-Forwarded-For", prior+", "+ip)
+		} else {
+			h.Set("X-Forwarded-For", ip)
+		}
+	}
+	if h.Get("X-Forwarded-Proto") == "" {
+		h.Set("X-Forwarded-Proto", proto)
+	}
+	if h.Get("X-Forwarded-Host") == "" && req.Host != "" {
+		h.Set("X-Forwarded-Host", req.Host)
+	}
+
+	elem := make([]string, 0, 3)
+	if ip != "" {
+		if strings.Contains(ip, ":") {
+			// IPv6
+			ip = "[" + ip + "]"
+		}
+		elem = append(elem, "for="+quoteForwarded(ip))
+	}
+	if req.Host != "" {
+		elem = append(elem, "host="+quoteForwarded(req.Host))
+	}
+	elem = append(elem, "proto="+proto)
+	h.Add("Forwarded", strings.Join(elem, ";"))
+
+	h.Add("Via", via(req))
+}
+
+func via(req *http.Request) string {
+	return fmt.Sprintf("%d.%d %v", req.ProtoMajor, req.ProtoMinor, ViaName)
+}
+
+// quoteForwarded quotes the value if it isn't a token.
+func quoteForwarded(val string) string {
+	for _, c := range val {
+		if !isTokenChar(c) {
+			return `"` + strings.Replace(strings.Replace(val, `\`, `\\`, -1), `"`, `\"`, -1) + `"`
+		}
+	}
+	return val
+}
+
+func isTokenChar(c rune) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	}
+	return strings.ContainsRune("!#$%&'*+-.^_`|~", c)
+}
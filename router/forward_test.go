@@ -0,0 +1,202 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+func TestRemoveHopHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Connection", "keep-alive, X-Foo")
+	h.Set("Keep-Alive", "timeout=5")
+	h.Set("X-Foo", "bar")
+	h.Set("Te", "trailers, deflate")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Content-Type", "text/plain")
+	cleanRequestHeaders(h)
+	for _, key := range []string{"Connection", "Keep-Alive", "X-Foo", "Transfer-Encoding"} {
+		if v := h.Get(key); v != "" {
+			t.Fatal("hop header not removed", key, v)
+		}
+	}
+	if v := h.Get("Te"); v != "trailers" {
+		t.Fatal("wrong te", v)
+	}
+	if v := h.Get("Content-Type"); v != "text/plain" {
+		t.Fatal("header removed", v)
+	}
+
+	h = http.Header{}
+	h.Set("Connection", "Upgrade")
+	h.Set("Upgrade", "websocket")
+	cleanRequestHeaders(h)
+	if v := h.Get("Upgrade"); v != "websocket" {
+		t.Fatal("upgrade header removed", v)
+	}
+	if v := h.Get("Connection"); v != "Upgrade" {
+		t.Fatal("connection header removed", v)
+	}
+}
+
+func TestForwardHeaders(t *testing.T) {
+	newReq := func() *http.Request {
+		r, err := http.NewRequest("GET", "http://example.com/foo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.RemoteAddr = "10.0.0.2:1234"
+		r.Header.Set("X-Forwarded-For", "1.2.3.4")
+		r.Header.Set("X-Forwarded-Proto", "https")
+		r.Header.Set("X-Forwarded-Host", "evil.com")
+		r.Header.Set("Forwarded", "for=1.2.3.4")
+		r.Header.Set("Via", "1.1 other")
+		return r
+	}
+
+	r := newReq()
+	forwardHeaders(r, false)
+	tests := map[string][]string{
+		"X-Forwarded-For":   {"10.0.0.2"},
+		"X-Forwarded-Proto": {"http"},
+		"X-Forwarded-Host":  {"example.com"},
+		"Forwarded":         {"for=10.0.0.2;host=example.com;proto=http"},
+		"Via":               {"1.1 other", "1.1 droute"},
+	}
+	for key, vals := range tests {
+		got := r.Header[key]
+		if len(got) != len(vals) {
+			t.Fatal("wrong header", key, got)
+		}
+		for i := range vals {
+			if got[i] != vals[i] {
+				t.Fatal("wrong header", key, got)
+			}
+		}
+	}
+
+	r = newReq()
+	forwardHeaders(r, true)
+	tests = map[string][]string{
+		"X-Forwarded-For":   {"1.2.3.4, 10.0.0.2"},
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"evil.com"},
+		"Forwarded":         {"for=1.2.3.4", "for=10.0.0.2;host=example.com;proto=http"},
+	}
+	for key, vals := range tests {
+		got := r.Header[key]
+		if len(got) != len(vals) {
+			t.Fatal("wrong header", key, got)
+		}
+		for i := range vals {
+			if got[i] != vals[i] {
+				t.Fatal("wrong header", key, got)
+			}
+		}
+	}
+
+	r = newReq()
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	r.Host = "example.com:8080"
+	forwardHeaders(r, false)
+	if v := r.Header.Get("Forwarded"); v != `for="[2001:db8::1]";host="example.com:8080";proto=http` {
+		t.Fatal("wrong forwarded", v)
+	}
+}
+
+func TestProxyForwarded(t *testing.T) {
+	HTTPClient = http.DefaultClient
+	var got http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Header().Set("Connection", "X-Secret")
+		w.Header().Set("X-Secret", "oi")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Foo", "bar")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	trust := false
+	rd := NewRedirDst(server.URL)
+	h := Forwarded(func() bool { return trust }, Balance(rd, Proxy("", time.Second)))
+	front := httptest.NewServer(responsewriter.Handler(h))
+	defer front.Close()
+
+	req, err := http.NewRequest("GET", front.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "hop")
+	req.Header.Set("X-Forwarded-For", "1.2.3.4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("wrong response code", resp.StatusCode)
+	}
+	if v := got.Get("X-Hop"); v != "" {
+		t.Fatal("hop header forwarded", v)
+	}
+	if v := got.Get("X-Forwarded-For"); v != "127.0.0.1" {
+		t.Fatal("wrong x-forwarded-for", v)
+	}
+	if v := got.Get("Via"); v != "1.1 droute" {
+		t.Fatal("wrong via", v)
+	}
+	if v := resp.Header.Get("X-Secret"); v != "" {
+		t.Fatal("hop header returned", v)
+	}
+	if v := resp.Header.Get("Keep-Alive"); v != "" {
+		t.Fatal("hop header returned", v)
+	}
+	if v := resp.Header.Get("X-Foo"); v != "bar" {
+		t.Fatal("header removed", v)
+	}
+	if v := resp.Header.Get("Via"); v != "1.1 droute" {
+		t.Fatal("wrong via", v)
+	}
+
+	trust = true
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if v := got.Get("X-Forwarded-For"); v != "1.2.3.4, 127.0.0.1" {
+		t.Fatal("wrong x-forwarded-for", v)
+	}
+}
+
+func TestRouterTrustForwardHeaders(t *testing.T) {
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	if r.trusted(DefaultRouter)() {
+		t.Fatal("trusted by default")
+	}
+	err = r.TrustForwardHeaders(DefaultRouter, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.trusted(DefaultRouter)() {
+		t.Fatal("not trusted")
+	}
+	err = r.TrustForwardHeaders("notfound", true)
+	if err == nil {
+		t.Fatal("router not found")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+
+	fhttp "github.com/fcavani/http"
+)
+
+// DefaultReplicas is the number of virtual nodes of each address in the ring.
+var DefaultReplicas = 100
+
+// HashKey extracts from the request the key used by ConsistentHash.
+type HashKey func(req *http.Request) string
+
+// HashRemoteIP uses the client ip as key.
+func HashRemoteIP() HashKey {
+	return func(req *http.Request) string {
+		ip, _ := fhttp.RemoteIP(req)
+		return ip
+	}
+}
+
+// HashHeader uses the value of the header name as key.
+func HashHeader(name string) HashKey {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// HashCookie uses the value of the cookie name as key.
+func HashCookie(name string) HashKey {
+	return func(req *http.Request) string {
+		c, err := req.Cookie(name)
+		if err != nil {
+			return ""
+		}
+		return c.Value
+	}
+}
+
+// HashQuery uses the value of the url query parameter name as key.
+func HashQuery(name string) HashKey {
+	return func(req *http.Request) string {
+		return req.URL.Query().Get(name)
+	}
+}
+
+// ring is a consistent hash ring with virtual nodes.
+type ring struct {
+	points []uint32
+	owners []string
+}
+
+func newRing(addrs []string, replicas int) *ring {
+	type point struct {
+		hash  uint32
+		owner string
+	}
+	points := make([]point, 0, len(addrs)*replicas)
+	for _, addr := range addrs {
+		for i := 0; i < replicas; i++ {
+			points = append(points, point{
+				hash:  crc32.ChecksumIEEE([]byte(addr + "#" + strconv.Itoa(i))),
+				owner: addr,
+			})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash == points[j].hash {
+			return points[i].owner < points[j].owner
+		}
+		return points[i].hash < points[j].hash
+	})
+	r := &ring{
+		points: make([]uint32, len(points)),
+		owners: make([]string, len(points)),
+	}
+	for i, p := range points {
+		r.points[i] = p.hash
+		r.owners[i] = p.owner
+	}
+	return r
+}
+
+// get returns the owner of key.
+func (r *ring) get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i] >= h
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// ConsistentHash sends the requests with the same key to the same address.
+// Adding or removing one address only remaps the keys of that address. If
+// the key is empty the client ip is used.
+type ConsistentHash struct {
+	*RoundRobin
+	key      HashKey
+	replicas int
+}
+
+// NewConsistentHash creates a new consistent hash balancer with key as the
+// key extractor and replicas virtual nodes for each address. If replicas is
+// zero DefaultReplicas is used.
+func NewConsistentHash(key HashKey, replicas int) *ConsistentHash {
+	if key == nil {
+		key = HashRemoteIP()
+	}
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &ConsistentHash{
+		RoundRobin: NewRoundRobin(),
+		key:        key,
+		replicas:   replicas,
+	}
+}
+
+// Clone returns a new empty consistent hash with the same key and replicas.
+func (ch *ConsistentHash) Clone() LoadBalance {
+	return NewConsistentHash(ch.key, ch.replicas)
+}
+
+// AddAddrs adds an ip to the ring.
+func (ch *ConsistentHash) AddAddrs(method, path, ip string) {
+	ch.RoundRobin.AddAddrs(method, path, ip)
+	ch.invalidate(method, path)
+}
+
+// Remove exclude a ip from the ring.
+func (ch *ConsistentHash) Remove(method, path, ip string) {
+	ch.RoundRobin.Remove(method, path, ip)
+	ch.invalidate(method, path)
+}
+
+func (ch *ConsistentHash) invalidate(method, path string) {
+	if path == "" {
+		path = "/"
+	}
+	ch.lck.Lock()
+	defer ch.lck.Unlock()
+	m, ok := ch.ips[method]
+	if !ok {
+		return
+	}
+	p := findPath(m, path)
+	if p == nil {
+		return
+	}
+	p.ring = nil
+}
+
+// Next returns the address for the empty key.
+func (ch *ConsistentHash) Next(method, path string) string {
+	return ch.next(method, path, "")
+}
+
+// NextRequest returns the address for the key of req.
+func (ch *ConsistentHash) NextRequest(req *http.Request, method, path string) string {
+	key := ch.key(req)
+	if key == "" {
+		key, _ = fhttp.RemoteIP(req)
+	}
+	return ch.next(method, path, key)
+}
+
+func (ch *ConsistentHash) next(method, path, key string) string {
+	if path == "" {
+		path = "/"
+	}
+	ch.lck.Lock()
+	defer ch.lck.Unlock()
+	m, ok := ch.ips[method]
+	if !ok {
+		return ""
+	}
+	p := findPath(m, path)
+	if p == nil || len(p.ips) == 0 {
+		return ""
+	}
+	if p.ring == nil {
+		p.ring = newRing(p.ips, ch.replicas)
+	}
+	return p.ring.get(key)
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+func TestConsistentHash(t *testing.T) {
+	ch := NewConsistentHash(HashHeader("X-User"), 0)
+	if dst := ch.Next("GET", "/"); dst != "" {
+		t.Fatal("next fail", dst)
+	}
+	for _, ip := range []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"} {
+		ch.AddAddrs("GET", "/", ip)
+	}
+
+	keys := make([]string, 1000)
+	before := make(map[string]string, len(keys))
+	count := make(map[string]int)
+	for i := range keys {
+		keys[i] = "user" + strconv.Itoa(i)
+		before[keys[i]] = ch.next("GET", "/", keys[i])
+		count[before[keys[i]]]++
+	}
+	if len(count) != 3 {
+		t.Fatal("bad distribution", count)
+	}
+
+	// Adding only moves keys to the new address.
+	ch.AddAddrs("GET", "/", "10.0.1.4")
+	moved := 0
+	for _, k := range keys {
+		dst := ch.next("GET", "/", k)
+		if dst == before[k] {
+			continue
+		}
+		if dst != "10.0.1.4" {
+			t.Fatal("key moved between old addresses", k, before[k], dst)
+		}
+		moved++
+	}
+	if moved == 0 || moved == len(keys) {
+		t.Fatal("wrong number of keys moved", moved)
+	}
+
+	// Removing only moves the keys of the removed address.
+	ch.Remove("GET", "/", "10.0.1.4")
+	ch.Remove("GET", "/", "10.0.1.2")
+	for _, k := range keys {
+		dst := ch.next("GET", "/", k)
+		if before[k] != "10.0.1.2" && dst != before[k] {
+			t.Fatal("key moved", k, before[k], dst)
+		}
+		if dst == "10.0.1.2" {
+			t.Fatal("removed address selected")
+		}
+	}
+}
+
+func TestHashKeys(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://localhost/?user=foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-User", "bar")
+	req.Header.Set("X-Real-Ip", "10.0.0.9")
+	req.AddCookie(&http.Cookie{Name: "user", Value: "baz"})
+
+	tests := []struct {
+		key    HashKey
+		result string
+	}{
+		{HashRemoteIP(), "10.0.0.9"},
+		{HashHeader("X-User"), "bar"},
+		{HashCookie("user"), "baz"},
+		{HashCookie("none"), ""},
+		{HashQuery("user"), "foo"},
+	}
+	for i, test := range tests {
+		if r := test.key(req); r != test.result {
+			t.Fatal("fail", i, r, test.result)
+		}
+	}
+}
+
+func TestBalanceConsistentHash(t *testing.T) {
+	ch := NewConsistentHash(HashQuery("user"), 0)
+	for _, ip := range []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"} {
+		ch.AddAddrs("GET", "/", ip)
+	}
+	var dst string
+	h := Balance(ch, func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		dst = req.Context().Value("proxyredirdst").(string)
+		rw.WriteHeader(200)
+	})
+	var first string
+	for i := 0; i < 5; i++ {
+		req, err := http.NewRequest("GET", "http://localhost/?user=foo", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h(responsewriter.NewResponseWriter(), req)
+		if i == 0 {
+			first = dst
+		}
+		if dst != first {
+			t.Fatal("same key in different addresses", first, dst)
+		}
+	}
+}
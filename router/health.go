@@ -0,0 +1,248 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+	log "github.com/fcavani/slog"
+)
+
+// Health is the state of a server.
+type Health string
+
+const (
+	// HealthUnknown is the state of a server not checked yet.
+	HealthUnknown Health = "unknown"
+	// HealthUp is the state of a server that answers the health checks.
+	HealthUp Health = "up"
+	// HealthDown is the state of a server that fails the health checks.
+	HealthDown Health = "down"
+	// HealthUnverified is the state of a server restored from the store that
+	// didn't pass a health check yet, see SetStore.
+	HealthUnverified Health = "unverified"
+)
+
+// HealthCheck configures the active health check of the servers registered
+// in the router.
+type HealthCheck struct {
+	// Path is the path probed in all servers.
+	Path string
+	// Interval between the checks.
+	Interval time.Duration
+	// Timeout of one check.
+	Timeout time.Duration
+	// Healthy is the number of consecutive successes to mark the server up.
+	Healthy int
+	// Unhealthy is the number of consecutive failures to mark the server down.
+	Unhealthy int
+}
+
+func (hc *HealthCheck) defaults() {
+	if hc.Path == "" {
+		hc.Path = "/"
+	}
+	if hc.Interval <= 0 {
+		hc.Interval = 10 * time.Second
+	}
+	if hc.Timeout <= 0 {
+		hc.Timeout = 2 * time.Second
+	}
+	if hc.Healthy <= 0 {
+		hc.Healthy = 2
+	}
+	if hc.Unhealthy <= 0 {
+		hc.Unhealthy = 3
+	}
+}
+
+// healthState is the result of the checks of one server.
+type healthState struct {
+	health    Health
+	successes int
+	failures  int
+}
+
+// HealthCheck starts the health check of the servers. Servers that fail are
+// removed from the LoadBalance and added again when they recover. Must be
+// called after Start and only once.
+func (r *Router) HealthCheck(hc *HealthCheck) error {
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+	if r.hc != nil {
+		return e.New("health check already started")
+	}
+	hc.defaults()
+	r.hc = hc
+	r.health = make(map[string]*healthState)
+	for addr := range r.unverified {
+		r.admit(addr, false)
+	}
+	go r.checker(hc, r.stop)
+	return nil
+}
+
+// checker probes the servers until stop is closed.
+func (r *Router) checker(hc *HealthCheck, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(hc.Interval):
+			r.check(hc)
+		}
+	}
+}
+
+func (r *Router) check(hc *HealthCheck) {
+	addrs := r.addrs()
+	results := make(map[string]bool, len(addrs))
+	var lck sync.Mutex
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			err := probe(addr, hc)
+			if err != nil {
+				log.Tag("router", "health").DebugLevel().Printf("Health check of %v failed: %v", addr, err)
+			}
+			lck.Lock()
+			results[addr] = err == nil
+			lck.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+	for addr := range r.health {
+		if _, found := results[addr]; !found {
+			delete(r.health, addr)
+		}
+	}
+	for addr := range r.unverified {
+		if _, found := results[addr]; !found {
+			delete(r.unverified, addr)
+		}
+	}
+	for addr, ok := range results {
+		before := r.healthOf(addr)
+		state, found := r.health[addr]
+		if !found {
+			state = &healthState{health: HealthUnknown}
+			r.health[addr] = state
+		}
+		if ok {
+			delete(r.unverified, addr)
+			state.failures = 0
+			state.successes++
+			if state.health != HealthUp && state.successes >= hc.Healthy {
+				state.health = HealthUp
+				log.Tag("router", "health").Printf("Server %v is up.", addr)
+			}
+		} else {
+			state.successes = 0
+			state.failures++
+			if state.health != HealthDown && state.failures >= hc.Unhealthy {
+				state.health = HealthDown
+				log.Tag("router", "health").Printf("Server %v is down.", addr)
+			}
+		}
+		if health := r.healthOf(addr); health != before {
+			r.publishHealth(addr, health)
+		}
+		r.admit(addr, state.health != HealthDown)
+	}
+}
+
+// admit adds or removes the server addr from the LoadBalance in all routes.
+// tableLck must be locked.
+func (r *Router) admit(addr string, up bool) {
+	for key, entry := range r.table {
+		if !entry.active {
+			continue
+		}
+		b := entry.get(addr)
+		if b == nil {
+			continue
+		}
+		if up {
+			if !r.available(entry, addr) {
+				continue
+			}
+			r.lbAdd(entry, key, b)
+		} else {
+			entry.remove(key, b)
+		}
+	}
+}
+
+// addrs returns all servers of the active routes.
+func (r *Router) addrs() []string {
+	r.tableLck.RLock()
+	defer r.tableLck.RUnlock()
+	seen := make(map[string]struct{})
+	addrs := make([]string, 0)
+	for _, entry := range r.table {
+		if !entry.active {
+			continue
+		}
+		for _, b := range entry.dsts {
+			if _, found := seen[b.addr]; found {
+				continue
+			}
+			seen[b.addr] = struct{}{}
+			addrs = append(addrs, b.addr)
+		}
+	}
+	return addrs
+}
+
+// healthOf returns the health of the server addr. tableLck must be locked.
+func (r *Router) healthOf(addr string) Health {
+	if !r.verified(addr) {
+		return HealthUnverified
+	}
+	if r.health == nil {
+		return HealthUnknown
+	}
+	state, found := r.health[addr]
+	if !found {
+		return HealthUnknown
+	}
+	return state.health
+}
+
+// probe does one health check in the server addr.
+func probe(addr string, hc *HealthCheck) error {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return e.Push(err, "invalid server address")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + hc.Path
+	ctx, cancel := context.WithTimeout(context.Background(), hc.Timeout)
+	defer cancel()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return e.Forward(err)
+	}
+	req = req.WithContext(ctx)
+	resp, err := HTTPClient.Do(req)
+	if err != nil {
+		return e.Forward(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+		return e.New("server answered with status code %v", resp.StatusCode)
+	}
+	return nil
+}
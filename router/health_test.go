@@ -0,0 +1,178 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+	"github.com/fcavani/e"
+)
+
+func TestHealthCheck(t *testing.T) {
+	var fail int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	HTTPClient = http.DefaultClient
+
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), 60*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	hc := &HealthCheck{
+		Path:      "/health",
+		Interval:  time.Hour,
+		Healthy:   2,
+		Unhealthy: 2,
+	}
+	err = r.HealthCheck(hc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.HealthCheck(hc)
+	if err != nil && !e.Contains(err, "health check already started") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+
+	err = r.Add(DefaultRouter, "GET", "/h", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := routeLB(r, DefaultRouter, "GET", "/h")
+
+	health := func() Health {
+		rs, err := r.Get(DefaultRouter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, route := range rs {
+			if route.Path == "/h" && len(route.Backends) == 1 {
+				return route.Backends[0].Health
+			}
+		}
+		t.Fatal("route not found")
+		return ""
+	}
+
+	if h := health(); h != HealthUnknown {
+		t.Fatal("wrong health", h)
+	}
+
+	r.check(hc)
+	r.check(hc)
+	if h := health(); h != HealthUp {
+		t.Fatal("wrong health", h)
+	}
+
+	atomic.StoreInt32(&fail, 1)
+	r.check(hc)
+	if h := health(); h != HealthUp {
+		t.Fatal("wrong health", h)
+	}
+	r.check(hc)
+	if h := health(); h != HealthDown {
+		t.Fatal("wrong health", h)
+	}
+	if dst := lb.Next("GET", "/h"); dst != "" {
+		t.Fatal("server down still in the load balance", dst)
+	}
+
+	atomic.StoreInt32(&fail, 0)
+	r.check(hc)
+	if dst := lb.Next("GET", "/h"); dst != "" {
+		t.Fatal("server readmitted too early", dst)
+	}
+	r.check(hc)
+	if h := health(); h != HealthUp {
+		t.Fatal("wrong health", h)
+	}
+	if dst := lb.Next("GET", "/h"); dst != server.URL {
+		t.Fatal("server wasn't readmitted", dst)
+	}
+
+	// A server removed from the load balance is readmitted by the next check.
+	lb.Remove("GET", "/h", server.URL)
+	r.check(hc)
+	if dst := lb.Next("GET", "/h"); dst != server.URL {
+		t.Fatal("server wasn't readmitted", dst)
+	}
+}
+
+func TestGetHandler(t *testing.T) {
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), 60*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	err = r.Add(DefaultRouter, "GET", "/get", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := json.Marshal(&Route{
+		Router: DefaultRouter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest("GET", "http://localhost/en/_router/get", bytes.NewBuffer(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("X-Real-Ip", "127.0.0.1")
+	rw := responsewriter.NewResponseWriter()
+	r.ServeHTTP(rw, req)
+	if code := rw.ResponseCode(); code != http.StatusOK {
+		t.Fatal("wrong response code", code)
+	}
+	var resp ResponseRoutes
+	err = json.NewDecoder(rw).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	found := false
+	for _, route := range resp.Routes {
+		if route.Path != "/get" {
+			continue
+		}
+		found = true
+		if len(route.Backends) != 1 {
+			t.Fatal("wrong number of backends", len(route.Backends))
+		}
+		if b := route.Backends[0]; b.Addr != "10.0.0.1" || b.Health != HealthUnknown {
+			t.Fatal("wrong backend", b.Addr, b.Health)
+		}
+	}
+	if !found {
+		t.Fatal("route not found")
+	}
+}
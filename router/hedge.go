@@ -0,0 +1,251 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+	log "github.com/fcavani/slog"
+)
+
+// HedgeSamples is the number of latencies used to compute the percentile.
+var HedgeSamples = 100
+
+// HedgeMinSamples is the number of latencies needed before the percentile is
+// used instead of the delay.
+var HedgeMinSamples = 10
+
+// HedgePolicy configures the hedged requests of a route. If the server doesn't
+// answer within the delay the request is sent to another server too, and the
+// first successful response is used.
+type HedgePolicy struct {
+	// Delay before sending the hedged request.
+	Delay time.Duration
+	// Percentile, between 0 and 100, of the route latency used as delay.
+	// While there isn't enough samples Delay is used.
+	Percentile float64
+	// MaxHedges is the max number of hedged requests. Defaults to 1.
+	MaxHedges int
+	// Methods are the methods hedged. Defaults to GET and HEAD.
+	Methods []string
+}
+
+func (hp HedgePolicy) withDefaults() *HedgePolicy {
+	if hp.MaxHedges <= 0 {
+		hp.MaxHedges = 1
+	}
+	if hp.Methods == nil {
+		hp.Methods = []string{"GET", "HEAD"}
+	}
+	return &hp
+}
+
+func (hp *HedgePolicy) hedgeable(req *http.Request) bool {
+	if isUpgrade(req) || (req.Body != nil && req.Body != http.NoBody) {
+		return false
+	}
+	for _, m := range hp.Methods {
+		if m == req.Method {
+			return true
+		}
+	}
+	return false
+}
+
+// latencies keeps the last latencies of a route.
+type latencies struct {
+	samples []time.Duration
+	next    int
+	lck     sync.Mutex
+}
+
+func newLatencies(n int) *latencies {
+	return &latencies{
+		samples: make([]time.Duration, 0, n),
+	}
+}
+
+func (l *latencies) add(d time.Duration) {
+	l.lck.Lock()
+	defer l.lck.Unlock()
+	if len(l.samples) < cap(l.samples) {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % len(l.samples)
+}
+
+// percentile returns the p percentile or false if there isn't enough samples.
+func (l *latencies) percentile(p float64) (time.Duration, bool) {
+	l.lck.Lock()
+	s := append([]time.Duration(nil), l.samples...)
+	l.lck.Unlock()
+	if len(s) == 0 || len(s) < HedgeMinSamples {
+		return 0, false
+	}
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	i := int(p / 100 * float64(len(s)-1))
+	if i < 0 {
+		i = 0
+	} else if i >= len(s) {
+		i = len(s) - 1
+	}
+	return s[i], true
+}
+
+type hedged struct {
+	w       *responsewriter.ResponseWriter
+	cancel  context.CancelFunc
+	elapsed time.Duration
+}
+
+func (h *hedged) failed() bool {
+	return serverError(h.w.ResponseCode())
+}
+
+// discard cancels the request and drops the response.
+func (h *hedged) discard() {
+	h.w.Reset()
+	h.cancel()
+}
+
+// Hedge sends the request to another server if the first one doesn't answer
+// within the delay of the policy. The first successful response is used and the
+// other requests are canceled. Use it before Balance, so each request goes to a
+// different server.
+func Hedge(policy *HedgePolicy, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	hp := policy.withDefaults()
+	lat := newLatencies(HedgeSamples)
+	return func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		delay := hp.Delay
+		if hp.Percentile > 0 {
+			if d, ok := lat.percentile(hp.Percentile); ok {
+				delay = d
+			}
+		}
+		if delay <= 0 || !hp.hedgeable(req) {
+			start := time.Now()
+			handler(rw, req)
+			lat.add(time.Since(start))
+			return
+		}
+
+		base := req.Context()
+		if _, ok := base.Value(ctxAttempts).(*attempts); !ok {
+			base = context.WithValue(base, ctxAttempts, &attempts{tried: make(map[string]bool)})
+		}
+		results := make(chan *hedged, 1+hp.MaxHedges)
+		var all []*hedged
+		launch := func() {
+			ctx, cancel := context.WithCancel(base)
+			h := &hedged{cancel: cancel}
+			if rw.Stream() {
+				h.w = responsewriter.NewStreamResponseWriter()
+			} else {
+				h.w = responsewriter.NewResponseWriter()
+			}
+			all = append(all, h)
+			r := req.Clone(ctx)
+			go func() {
+				start := time.Now()
+				handler(h.w, r)
+				h.elapsed = time.Since(start)
+				results <- h
+			}()
+		}
+
+		launch()
+		launched, running := 1, 1
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		var winner *hedged
+		var failed []*hedged
+		for running > 0 && winner == nil {
+			select {
+			case h := <-results:
+				running--
+				if h.failed() {
+					failed = append(failed, h)
+					continue
+				}
+				winner = h
+			case <-timer.C:
+				if launched > hp.MaxHedges {
+					continue
+				}
+				log.Tag("router", "hedge").DebugLevel().Printf("hedging request (%v, %v) after %v", req.Method, req.URL.Path, delay)
+				launch()
+				launched++
+				running++
+				timer.Reset(delay)
+			}
+		}
+		if winner == nil {
+			// All failed, use the first response.
+			winner = failed[0]
+			failed = failed[1:]
+		}
+		for _, h := range failed {
+			h.discard()
+		}
+		// Cancel the requests still running.
+		for _, h := range all {
+			if h != winner {
+				h.cancel()
+			}
+		}
+		go func(running int) {
+			for i := 0; i < running; i++ {
+				h := <-results
+				h.discard()
+			}
+		}(running)
+		if !winner.failed() {
+			lat.add(winner.elapsed)
+		}
+
+		header := rw.Header()
+		for k, vals := range winner.w.Header() {
+			for _, val := range vals {
+				header.Add(k, val)
+			}
+		}
+		if code := winner.w.ResponseCode(); code != 0 {
+			rw.WriteHeader(code)
+		}
+		rw.Write(winner.w.Bytes())
+		if body := winner.w.TakeBody(); body != nil {
+			rw.SetBody(&hedgedBody{ReadCloser: body, cancel: winner.cancel})
+			return
+		}
+		winner.cancel()
+	}
+}
+
+// hedge returns handler if the route has no hedge policy.
+func hedge(policy *HedgePolicy, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	if policy == nil {
+		return handler
+	}
+	return Hedge(policy, handler)
+}
+
+// hedgedBody cancels the request of the winner when the body is closed.
+type hedgedBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (hb *hedgedBody) Close() error {
+	defer hb.cancel()
+	return hb.ReadCloser.Close()
+}
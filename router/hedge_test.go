@@ -0,0 +1,145 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+func TestHedge(t *testing.T) {
+	HTTPClient = http.DefaultClient
+	canceled := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(canceled)
+		case <-time.After(2 * time.Second):
+		}
+		w.Write([]byte("slow"))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("fast"))
+	}))
+	defer fast.Close()
+
+	rr := NewRoundRobin()
+	rr.AddAddrs("GET", "/", slow.URL)
+	rr.AddAddrs("GET", "/", fast.URL)
+	front := httptest.NewServer(responsewriter.Handler(
+		Hedge(&HedgePolicy{Delay: 50 * time.Millisecond}, Balance(rr, Proxy("", 5*time.Second))),
+	))
+	defer front.Close()
+
+	start := time.Now()
+	resp, err := http.Get(front.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "fast" {
+		t.Fatal("wrong response", string(buf))
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatal("hedge too slow", d)
+	}
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("slow request not canceled")
+	}
+}
+
+func TestHedgeNotNeeded(t *testing.T) {
+	count := 0
+	h := Hedge(&HedgePolicy{Delay: 100 * time.Millisecond}, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		count++
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte("oi"))
+	})
+	w := responsewriter.NewResponseWriter()
+	r, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(w, r)
+	if count != 1 {
+		t.Fatal("request hedged", count)
+	}
+	if code := w.ResponseCode(); code != http.StatusOK {
+		t.Fatal("wrong response code", code)
+	}
+	if body := string(w.Bytes()); body != "oi" {
+		t.Fatal("wrong body", body)
+	}
+
+	// Only GET and HEAD by default.
+	count = 0
+	r, err = http.NewRequest("DELETE", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h = Hedge(&HedgePolicy{Delay: time.Millisecond}, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		count++
+		time.Sleep(20 * time.Millisecond)
+		rw.WriteHeader(http.StatusOK)
+	})
+	h(responsewriter.NewResponseWriter(), r)
+	if count != 1 {
+		t.Fatal("request hedged", count)
+	}
+}
+
+func TestHedgeFailed(t *testing.T) {
+	h := Hedge(&HedgePolicy{Delay: 10 * time.Millisecond, MaxHedges: 2}, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		rw.WriteHeader(http.StatusBadGateway)
+	})
+	w := responsewriter.NewResponseWriter()
+	r, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(w, r)
+	if code := w.ResponseCode(); code != http.StatusBadGateway {
+		t.Fatal("wrong response code", code)
+	}
+}
+
+func TestLatencies(t *testing.T) {
+	l := newLatencies(20)
+	for i := 1; i <= HedgeMinSamples-1; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	if _, ok := l.percentile(50); ok {
+		t.Fatal("not enough samples")
+	}
+	for i := HedgeMinSamples; i <= 30; i++ {
+		l.add(time.Duration(i) * time.Millisecond)
+	}
+	// The last 20 samples, 11ms to 30ms.
+	d, ok := l.percentile(0)
+	if !ok || d != 11*time.Millisecond {
+		t.Fatal("wrong percentile", d)
+	}
+	d, ok = l.percentile(100)
+	if !ok || d != 30*time.Millisecond {
+		t.Fatal("wrong percentile", d)
+	}
+	d, ok = l.percentile(50)
+	if !ok || d != 20*time.Millisecond {
+		t.Fatal("wrong percentile", d)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"time"
+
+	"github.com/fcavani/e"
+	log "github.com/fcavani/slog"
+)
+
+// LeaseReapInterval is the interval between the searches for expired leases.
+var LeaseReapInterval = time.Second
+
+// Renew extends the lease of the server dst for more ttl time. If ttl is zero
+// the server will not expire anymore.
+func (r *Router) Renew(routerName, method, path, dst string, ttl time.Duration) (err error) {
+	defer func() {
+		if err != nil {
+			log.DebugLevel().Printf("Can't renew lease (%v, %v, %v => %v) error: %v", routerName, method, path, dst, err)
+		}
+	}()
+	path, err = checkRoute(routerName, method, path, dst)
+	if err != nil {
+		return
+	}
+
+	key := routeKey{router: routerName, method: method, path: path}
+
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+
+	entry, found := r.table[key]
+	if !found || !entry.active {
+		err = e.New("route not found")
+		return
+	}
+	b := entry.get(dst)
+	if b == nil {
+		err = e.New("destiny not found")
+		return
+	}
+	changed := b.ttl != ttl
+	b.lease(ttl)
+	if changed {
+		r.save(key, entry)
+	}
+	return
+}
+
+// reaper removes the servers with the lease expired and the drained ones until
+// stop is closed.
+func (r *Router) reaper(stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(LeaseReapInterval):
+			r.reap(time.Now())
+		}
+	}
+}
+
+func (r *Router) reap(now time.Time) {
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+	for key, entry := range r.table {
+		if !entry.active {
+			continue
+		}
+		removed := false
+		for _, b := range append([]*backend(nil), entry.dsts...) {
+			switch {
+			case b.expired(now):
+				log.Tag("router", "lease").Printf("Lease expired, server removed (%v, %v, %v => %v)", key.router, key.method, key.path, b.addr)
+			case b.drained(now):
+				log.Tag("router", "drain").Printf("Server drained and removed (%v, %v, %v => %v)", key.router, key.method, key.path, b.addr)
+			default:
+				continue
+			}
+			entry.del(b.addr)
+			entry.remove(key, b)
+			r.publish(EventRemove, key, b, "")
+			removed = true
+		}
+		if len(entry.dsts) == 0 {
+			entry.active = false
+			log.DebugLevel().Printf("Route (%v, %v, %v) disabled, no more servers.", key.router, key.method, key.path)
+		}
+		if removed {
+			r.save(key, entry)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+	"github.com/fcavani/e"
+)
+
+func TestLease(t *testing.T) {
+	r := &Router{}
+
+	err := r.Start(NewRouters(), NewRoundRobin(), 60*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	HTTPClient = &http.Client{
+		Transport: &transport{},
+	}
+	defer func() {
+		HTTPClient = http.DefaultClient
+	}()
+
+	err = r.Register(&Route{
+		Methode: "GET",
+		Router:  DefaultRouter,
+		Path:    "/lease",
+		RedirTo: "10.0.0.1",
+		TTL:     time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Register(&Route{
+		Methode: "GET",
+		Router:  DefaultRouter,
+		Path:    "/lease",
+		RedirTo: "10.0.0.2",
+		TTL:     time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.reap(time.Now().Add(2 * time.Minute))
+
+	for i := 0; i < 2; i++ {
+		w := responsewriter.NewResponseWriter()
+		req, err := http.NewRequest("GET", "http://localhost/en/lease", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.ServeHTTP(w, req)
+		if code := w.ResponseCode(); code != 200 {
+			t.Fatal("wrong response code", code)
+		}
+		if dst := w.Header().Get("X-Dst-Serv"); dst != "10.0.0.2" {
+			t.Fatal("wrong destiny", dst)
+		}
+	}
+
+	err = r.Renew(DefaultRouter, "GET", "/lease", "10.0.0.1", time.Hour)
+	if err != nil && !e.Contains(err, "destiny not found") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+
+	err = r.Renew(DefaultRouter, "GET", "/lease", "10.0.0.2", 3*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.reap(time.Now().Add(2 * time.Hour))
+
+	w := responsewriter.NewResponseWriter()
+	req, err := http.NewRequest("GET", "http://localhost/en/lease", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.ServeHTTP(w, req)
+	if code := w.ResponseCode(); code != 200 {
+		t.Fatal("wrong response code", code)
+	}
+
+	r.reap(time.Now().Add(4 * time.Hour))
+
+	w = responsewriter.NewResponseWriter()
+	req, err = http.NewRequest("GET", "http://localhost/en/lease", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.ServeHTTP(w, req)
+	if code := w.ResponseCode(); code != 404 {
+		t.Fatal("wrong response code", code)
+	}
+
+	err = r.Renew(DefaultRouter, "GET", "/lease", "10.0.0.2", time.Hour)
+	if err != nil && !e.Contains(err, "route not found") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+}
+
+func TestLeaseNoTTL(t *testing.T) {
+	r := &Router{}
+
+	err := r.Start(NewRouters(), NewRoundRobin(), 60*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	err = r.Add(DefaultRouter, "GET", "/forever", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.reap(time.Now().Add(100 * time.Hour))
+
+	rs, err := r.Get(DefaultRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.Search("/forever") {
+		t.Fatal("route without lease was removed")
+	}
+}
+
+func TestRenewHandler(t *testing.T) {
+	r := &Router{}
+
+	err := r.Start(NewRouters(), NewRoundRobin(), 60*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	route := &Route{
+		Methode: "GET",
+		Router:  DefaultRouter,
+		Path:    "/",
+		RedirTo: "10.0.0.1",
+		TTL:     time.Minute,
+	}
+
+	buf, err := json.Marshal(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("POST", "http://localhost/en/_router/renew", bytes.NewBuffer(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("X-Real-Ip", "127.0.0.1")
+	rw := responsewriter.NewResponseWriter()
+	r.ServeHTTP(rw, req)
+	if code := rw.ResponseCode(); code != 422 {
+		t.Fatal("wrong response code", code)
+	}
+
+	err = r.Register(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err = http.NewRequest("POST", "http://localhost/en/_router/renew", bytes.NewBuffer(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("X-Real-Ip", "127.0.0.1")
+	rw = responsewriter.NewResponseWriter()
+	r.ServeHTTP(rw, req)
+	if code := rw.ResponseCode(); code != 200 {
+		t.Fatal("wrong response code", code)
+	}
+	var resp Response
+	err = json.NewDecoder(rw).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Op != "renew" {
+		t.Fatal("wrong operation", resp.Op)
+	}
+}
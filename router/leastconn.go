@@ -0,0 +1,175 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// conns counts the requests in flight for each address.
+type conns struct {
+	n   map[string]int
+	lck sync.Mutex
+}
+
+func newConns() *conns {
+	return &conns{
+		n: make(map[string]int),
+	}
+}
+
+// inc increments the requests in flight for ip. lck must be locked.
+func (c *conns) inc(ip string) {
+	c.n[ip]++
+}
+
+func (c *conns) done(ip string) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	n := c.n[ip] - 1
+	if n <= 0 {
+		delete(c.n, ip)
+		return
+	}
+	c.n[ip] = n
+}
+
+func (c *conns) inFlight(ip string) int {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	return c.n[ip]
+}
+
+// LeastConnections sends the request to the address with less requests in
+// flight. Ties are broken in round robin.
+type LeastConnections struct {
+	*RoundRobin
+	conns  *conns
+	actual int
+}
+
+// NewLeastConnections creates a new least connections balancer.
+func NewLeastConnections() *LeastConnections {
+	return &LeastConnections{
+		RoundRobin: NewRoundRobin(),
+		conns:      newConns(),
+	}
+}
+
+// Clone returns a new empty least connections balancer.
+func (lc *LeastConnections) Clone() LoadBalance {
+	return NewLeastConnections()
+}
+
+// Next returns the address with less requests in flight.
+func (lc *LeastConnections) Next(method, path string) string {
+	ips := lc.addrs(method, path)
+	if len(ips) == 0 {
+		return ""
+	}
+	lc.conns.lck.Lock()
+	defer lc.conns.lck.Unlock()
+	start := lc.actual % len(ips)
+	lc.actual++
+	best := ips[start]
+	for i := 1; i < len(ips); i++ {
+		ip := ips[(start+i)%len(ips)]
+		if lc.conns.n[ip] < lc.conns.n[best] {
+			best = ip
+		}
+	}
+	lc.conns.inc(best)
+	return best
+}
+
+// Done decrements the requests in flight for ip.
+func (lc *LeastConnections) Done(method, path, ip string) {
+	lc.conns.done(ip)
+}
+
+// InFlight returns the number of requests in flight for ip.
+func (lc *LeastConnections) InFlight(ip string) int {
+	return lc.conns.inFlight(ip)
+}
+
+// Random sends the request to a random address.
+type Random struct {
+	*RoundRobin
+}
+
+// NewRandom creates a new random balancer.
+func NewRandom() *Random {
+	return &Random{
+		RoundRobin: NewRoundRobin(),
+	}
+}
+
+// Clone returns a new empty random balancer.
+func (r *Random) Clone() LoadBalance {
+	return NewRandom()
+}
+
+// Next returns a random address.
+func (r *Random) Next(method, path string) string {
+	ips := r.addrs(method, path)
+	if len(ips) == 0 {
+		return ""
+	}
+	return ips[rand.Intn(len(ips))]
+}
+
+// P2C picks two random addresses and sends the request to the one with less
+// requests in flight (power of two random choices).
+type P2C struct {
+	*RoundRobin
+	conns *conns
+}
+
+// NewP2C creates a new power of two choices balancer.
+func NewP2C() *P2C {
+	return &P2C{
+		RoundRobin: NewRoundRobin(),
+		conns:      newConns(),
+	}
+}
+
+// Clone returns a new empty power of two choices balancer.
+func (p *P2C) Clone() LoadBalance {
+	return NewP2C()
+}
+
+// Next returns the less loaded of two random addresses.
+func (p *P2C) Next(method, path string) string {
+	ips := p.addrs(method, path)
+	if len(ips) == 0 {
+		return ""
+	}
+	p.conns.lck.Lock()
+	defer p.conns.lck.Unlock()
+	i := rand.Intn(len(ips))
+	best := ips[i]
+	if len(ips) > 1 {
+		j := rand.Intn(len(ips) - 1)
+		if j == i {
+			j = len(ips) - 1
+		}
+		if other := ips[j]; p.conns.n[other] < p.conns.n[best] {
+			best = other
+		}
+	}
+	p.conns.inc(best)
+	return best
+}
+
+// Done decrements the requests in flight for ip.
+func (p *P2C) Done(method, path, ip string) {
+	p.conns.done(ip)
+}
+
+// InFlight returns the number of requests in flight for ip.
+func (p *P2C) InFlight(ip string) int {
+	return p.conns.inFlight(ip)
+}
@@ -0,0 +1,148 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+func TestLeastConnections(t *testing.T) {
+	lc := NewLeastConnections()
+	if dst := lc.Next("GET", "/"); dst != "" {
+		t.Fatal("next fail", dst)
+	}
+	lc.AddAddrs("GET", "/", "10.0.1.1")
+	lc.AddAddrs("GET", "/", "10.0.1.2")
+	lc.AddAddrs("GET", "/", "10.0.1.3")
+
+	// Without requests in flight it is a round robin.
+	for _, v := range []string{"10.0.1.1", "10.0.1.2", "10.0.1.3"} {
+		dst := lc.Next("GET", "/")
+		if dst != v {
+			t.Fatal("addrs invalid", dst, v)
+		}
+	}
+	lc.Done("GET", "/", "10.0.1.1")
+	lc.Done("GET", "/", "10.0.1.3")
+	// 10.0.1.2 is busy.
+	for i := 0; i < 4; i++ {
+		dst := lc.Next("GET", "/")
+		if dst == "10.0.1.2" {
+			t.Fatal("busy address selected")
+		}
+		lc.Done("GET", "/", dst)
+	}
+	if n := lc.InFlight("10.0.1.2"); n != 1 {
+		t.Fatal("wrong in flight", n)
+	}
+	lc.Done("GET", "/", "10.0.1.2")
+	lc.Done("GET", "/", "10.0.1.2")
+	if n := lc.InFlight("10.0.1.2"); n != 0 {
+		t.Fatal("wrong in flight", n)
+	}
+
+	lc.Remove("GET", "/", "10.0.1.1")
+	lc.Remove("GET", "/", "10.0.1.3")
+	for i := 0; i < 3; i++ {
+		if dst := lc.Next("GET", "/"); dst != "10.0.1.2" {
+			t.Fatal("addrs invalid", dst)
+		}
+	}
+}
+
+func TestRandom(t *testing.T) {
+	r := NewRandom()
+	if dst := r.Next("GET", "/"); dst != "" {
+		t.Fatal("next fail", dst)
+	}
+	r.AddAddrs("GET", "/", "10.0.1.1")
+	r.AddAddrs("GET", "/", "10.0.1.2")
+	seen := make(map[string]int)
+	for i := 0; i < 200; i++ {
+		dst := r.Next("GET", "/")
+		r.Done("GET", "/", dst)
+		seen[dst]++
+	}
+	if len(seen) != 2 || seen["10.0.1.1"] == 0 || seen["10.0.1.2"] == 0 {
+		t.Fatal("bad distribution", seen)
+	}
+}
+
+func TestP2C(t *testing.T) {
+	p := NewP2C()
+	if dst := p.Next("GET", "/"); dst != "" {
+		t.Fatal("next fail", dst)
+	}
+	p.AddAddrs("GET", "/", "10.0.1.1")
+	if dst := p.Next("GET", "/"); dst != "10.0.1.1" {
+		t.Fatal("addrs invalid", dst)
+	}
+	p.AddAddrs("GET", "/", "10.0.1.2")
+	// 10.0.1.1 has one request in flight, with two addresses both are always
+	// chosen and the idle one wins.
+	for i := 0; i < 10; i++ {
+		dst := p.Next("GET", "/")
+		if dst != "10.0.1.2" {
+			t.Fatal("busy address selected", dst)
+		}
+		p.Done("GET", "/", dst)
+	}
+	p.Done("GET", "/", "10.0.1.1")
+	if n := p.InFlight("10.0.1.1"); n != 0 {
+		t.Fatal("wrong in flight", n)
+	}
+}
+
+func TestBalanceDone(t *testing.T) {
+	lc := NewLeastConnections()
+	lc.AddAddrs("GET", "/", "10.0.1.1")
+	h := Balance(lc, func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		if n := lc.InFlight("10.0.1.1"); n != 1 {
+			t.Fatal("wrong in flight", n)
+		}
+		rw.WriteHeader(200)
+	})
+	rw := responsewriter.NewResponseWriter()
+	req, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(rw, req)
+	if n := lc.InFlight("10.0.1.1"); n != 0 {
+		t.Fatal("wrong in flight", n)
+	}
+}
+
+func TestBalanceDoneStream(t *testing.T) {
+	lc := NewLeastConnections()
+	lc.AddAddrs("GET", "/", "10.0.1.1")
+	h := Balance(lc, func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(200)
+		rw.SetBody(ioutil.NopCloser(strings.NewReader("streamed body")))
+	})
+	rw := responsewriter.NewStreamResponseWriter()
+	req, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(rw, req)
+	// The body is still being sent.
+	if n := lc.InFlight("10.0.1.1"); n != 1 {
+		t.Fatal("wrong in flight", n)
+	}
+	err = rw.Copy(httptest.NewRecorder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := lc.InFlight("10.0.1.1"); n != 0 {
+		t.Fatal("wrong in flight", n)
+	}
+}
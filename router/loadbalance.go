@@ -6,6 +6,7 @@ package router
 
 import (
 	"context"
+	"io"
 	"net/http"
 	"strings"
 	"sync"
@@ -25,6 +26,58 @@ type LoadBalance interface {
 	AddAddrs(method, path, ip string)
 	Next(method, path string) string
 	Remove(method, path, ip string)
+	// Done is called when the request sent to ip returned by Next finishes,
+	// after its streamed body is sent to the client.
+	Done(method, path, ip string)
+}
+
+// Cloner is implemented by the balancers that can create a new empty balancer
+// with the same configuration. The router uses it to give each route its own
+// LoadBalance.
+type Cloner interface {
+	Clone() LoadBalance
+}
+
+// clone returns a new LoadBalance like lb or lb itself if it isn't a Cloner.
+func clone(lb LoadBalance) LoadBalance {
+	if c, ok := lb.(Cloner); ok {
+		return c.Clone()
+	}
+	return lb
+}
+
+// Weighter is implemented by the balancers that honor the weight of the
+// addresses.
+type Weighter interface {
+	SetWeight(method, path, ip string, weight int)
+}
+
+// RequestLoadBalance is implemented by the balancers that choose the address
+// based on the request. Balance uses NextRequest instead of Next if the
+// LoadBalance implements it.
+type RequestLoadBalance interface {
+	NextRequest(req *http.Request, method, path string) string
+}
+
+// Reporter is implemented by the balancers that want to know the response
+// code of the requests, like OutlierDetection. Balance calls Report after each
+// request, like Done.
+type Reporter interface {
+	Report(method, path, ip string, code int)
+}
+
+// Container is implemented by the balancers that can tell if an address is
+// in the route.
+type Container interface {
+	Contains(method, path, ip string) bool
+}
+
+func contains(lb LoadBalance, method, path, ip string) bool {
+	c, ok := lb.(Container)
+	if !ok {
+		return false
+	}
+	return c.Contains(method, path, ip)
 }
 
 // RedirDst redirects the resquest to a single address.
@@ -54,9 +107,29 @@ func (rd *RedirDst) Remove(method, path, ip string) {
 	return
 }
 
+// Done do nothing
+func (rd *RedirDst) Done(method, path, ip string) {
+	return
+}
+
+// Contains returns true if ip is the address.
+func (rd *RedirDst) Contains(method, path, ip string) bool {
+	return ip == rd.dst
+}
+
+// Clone returns a balancer with the same address.
+func (rd *RedirDst) Clone() LoadBalance {
+	return NewRedirDst(rd.dst)
+}
+
 type ips struct {
 	ips    []string
 	actual int
+	// weight and current are only used by WeightedRoundRobin.
+	weight  map[string]int
+	current map[string]int
+	// ring is only used by ConsistentHash.
+	ring *ring
 }
 
 // RoundRobin make a rounding robin list of ip addresses of destinies.
@@ -72,6 +145,11 @@ func NewRoundRobin() *RoundRobin {
 	}
 }
 
+// Clone returns a new empty round robin.
+func (rr *RoundRobin) Clone() LoadBalance {
+	return NewRoundRobin()
+}
+
 // AddAddrs adds an ip to the list.
 func (rr *RoundRobin) AddAddrs(method, path, ip string) {
 	if path == "" {
@@ -105,8 +183,9 @@ func (rr *RoundRobin) Next(method, path string) string {
 	if path == "" {
 		path = "/"
 	}
-	rr.lck.RLock()
-	defer rr.lck.RUnlock()
+	// Lock, Next changes the actual position.
+	rr.lck.Lock()
+	defer rr.lck.Unlock()
 	m, ok := rr.ips[method]
 	if !ok {
 		return ""
@@ -127,6 +206,39 @@ func (rr *RoundRobin) Next(method, path string) string {
 	return p.ips[p.actual]
 }
 
+// addrs returns a copy of the addresses for method and path.
+func (rr *RoundRobin) addrs(method, path string) []string {
+	if path == "" {
+		path = "/"
+	}
+	rr.lck.RLock()
+	defer rr.lck.RUnlock()
+	m, ok := rr.ips[method]
+	if !ok {
+		return nil
+	}
+	p := findPath(m, path)
+	if p == nil {
+		return nil
+	}
+	return append([]string(nil), p.ips...)
+}
+
+// Done do nothing
+func (rr *RoundRobin) Done(method, path, ip string) {
+	return
+}
+
+// Contains returns true if ip is in the list.
+func (rr *RoundRobin) Contains(method, path, ip string) bool {
+	for _, addr := range rr.addrs(method, path) {
+		if addr == ip {
+			return true
+		}
+	}
+	return false
+}
+
 //Remove exclude a ip from the list of proxies
 func (rr *RoundRobin) Remove(method, path, target string) {
 	if path == "" {
@@ -146,28 +258,64 @@ func (rr *RoundRobin) Remove(method, path, target string) {
 		if ip != target {
 			continue
 		}
-		if i > 0 && i+1 < len(p.ips) {
-			p.ips = append(p.ips[:i-1], p.ips[i+1:]...)
-		} else if i == 0 && i+1 < len(p.ips) {
-			p.ips = p.ips[i+1:]
-		} else if i > 0 && i+1 == len(p.ips) {
-			p.ips = p.ips[:i-1]
-		} else if i == 0 && len(p.ips) == 1 {
-			p.ips = p.ips[:0]
-		}
+		p.ips = append(p.ips[:i], p.ips[i+1:]...)
 		break
 	}
 }
 
-// Balance is the handler that inserts in the context the next ip address.
+// Balance is the handler that inserts in the context the next ip address. The
+// servers that fail aren't removed, wrap the LoadBalance with
+// NewOutlierDetection for that.
 func Balance(lb LoadBalance, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	return balance(lb, nil, handler)
+}
+
+func balance(lb LoadBalance, affinity *AffinityCookie, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
 	return func(rw *responsewriter.ResponseWriter, req *http.Request) {
 		lang := httprouter.ContentLang(req)
-		path := req.URL.Path
-		if lang != "" {
-			path = strings.TrimPrefix(req.URL.Path, "/"+lang)
+		path, ok := routePattern(req)
+		if !ok {
+			path = req.URL.Path
+			if lang != "" {
+				path = strings.TrimPrefix(req.URL.Path, "/"+lang)
+			}
+		}
+		next := func() string {
+			if rlb, ok := lb.(RequestLoadBalance); ok {
+				return rlb.NextRequest(req, req.Method, path)
+			}
+			return lb.Next(req.Method, path)
+		}
+		var dst string
+		if affinity != nil {
+			dst = affinity.Backend(req)
+			if dst != "" && (!contains(lb, req.Method, path, dst) || tried(req, dst)) {
+				dst = ""
+			}
+		}
+		picked := false
+		if dst == "" {
+			dst = next()
+			picked = true
+			// A retry goes to a server not tried yet.
+			for i := 0; dst != "" && tried(req, dst) && i < failoverPicks; i++ {
+				lb.Done(req.Method, path, dst)
+				dst = next()
+			}
+			if dst != "" && tried(req, dst) {
+				if other := untried(lb, req, path); other != "" {
+					lb.Done(req.Method, path, dst)
+					dst = other
+					picked = false
+				}
+			}
+			if dst != "" && affinity != nil {
+				err := affinity.Set(rw, dst)
+				if err != nil {
+					log.Tag("router", "loadbalance").Error("Can't set the affinity cookie: ", err)
+				}
+			}
 		}
-		dst := lb.Next(req.Method, path)
 		if dst == "" {
 			log.Tag("router", "loadbalance").DebugLevel().Printf(
 				"no proxy ip (%v, %v, %v)",
@@ -185,58 +333,78 @@ func Balance(lb LoadBalance, handler responsewriter.HandlerFunc) responsewriter.
 			req.URL.Path,
 			lang,
 		)
+		try(req, dst)
 		req = req.WithContext(context.WithValue(req.Context(), ctxName, dst))
 		handler(rw, req)
 		code := rw.ResponseCode()
-		// TODO: 500 is for server error not fatal...
-		if code > 500 && code < 600 {
-			log.Tag("router", "loadbalance").DebugLevel().Printf("remove proxy %v (%v)", dst, code)
-			lb.Remove(req.Method, req.URL.Path, dst)
+		done := func() {
+			if picked {
+				lb.Done(req.Method, path, dst)
+			}
+			if r, ok := lb.(Reporter); ok {
+				r.Report(req.Method, path, dst, code)
+			}
+		}
+		// The request finishes when its streamed body is sent.
+		if body := rw.TakeBody(); body != nil {
+			rw.SetBody(&doneBody{ReadCloser: body, done: done})
+			return
 		}
+		done()
 	}
 }
 
-//TODO: random, least used...
+// doneBody calls done when closed.
+type doneBody struct {
+	io.ReadCloser
+	done func()
+	once sync.Once
+}
 
-func findPath(m map[string]*ips, path string) *ips {
-	p, ok := m[path]
-	if ok {
-		return p
-	}
-	for k, p := range m {
-		//if strings.Contains(k, "/*") || matchNamedParam(path, k) {
-		// log.Println("###", path, k)
-		if matchWildcard(path, k) || matchNamedParam(path, k) {
-			return p
-		}
-	}
-	return nil
+func (db *doneBody) Close() error {
+	db.once.Do(db.done)
+	return db.ReadCloser.Close()
 }
 
-func matchWildcard(path, k string) bool {
-	if k == "/" {
-		return false
+// failoverPicks is the number of times that balance asks the LoadBalance for a
+// server not tried yet before looking for one in the list of servers.
+const failoverPicks = 3
+
+type addrser interface {
+	addrs(method, path string) []string
+}
+
+// untried returns a server of the route not tried by the request.
+func untried(lb LoadBalance, req *http.Request, path string) string {
+	a, ok := lb.(addrser)
+	if !ok {
+		return ""
 	}
-	s := strings.Split(k, "/*")
-	if len(s) > 0 {
-		if strings.HasPrefix(path, s[0]) {
-			return true
+	for _, addr := range a.addrs(req.Method, path) {
+		if !tried(req, addr) {
+			return addr
 		}
 	}
-	return false
+	return ""
 }
 
-func matchNamedParam(path, k string) bool {
-	if path == "" || k == "" {
-		return false
+// findPath returns the addresses of the pattern that matches path. The path
+// can be the pattern itself. If more than one pattern matches the one with
+// precedence is used, see lessPattern.
+func findPath(m map[string]*ips, path string) *ips {
+	p, ok := m[path]
+	if ok {
+		return p
 	}
-	for i, p := range path {
-		if i < len(k) && p != rune(k[i]) {
-			if k[i] == ':' {
-				return true
-			}
-			return false
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sortPatterns(keys)
+	for _, k := range keys {
+		if matchPattern(k, path) {
+			return m[k]
 		}
 	}
-	return path == k
+	return nil
 }
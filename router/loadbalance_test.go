@@ -5,8 +5,10 @@
 package router
 
 import (
+	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/fcavani/droute/responsewriter"
 )
@@ -52,6 +54,19 @@ func TestRoundRobin(t *testing.T) {
 		}
 	}
 
+	rr.AddAddrs("GET", "/", "10.0.1.4")
+	rr.Remove("GET", "/", "10.0.1.3")
+	for _, v := range []string{"10.0.1.2", "10.0.1.4"} {
+		dst := rr.Next("GET", "/")
+		if dst != v {
+			t.Fatal("addrs invalid", dst, v)
+		}
+	}
+	rr.Remove("GET", "/", "10.0.1.4")
+	if dst := rr.Next("GET", "/"); dst != "10.0.1.2" {
+		t.Fatal("addrs invalid", dst)
+	}
+
 	rr.AddAddrs("GET", "/*catoto", "10.0.1.1")
 	rr.Remove("GET", "/*catoto", "10.0.1.1")
 	rr.Remove("GET", "/*notfound", "10.0.1.1")
@@ -80,22 +95,54 @@ func TestHandler(t *testing.T) {
 
 }
 
-func TestMatchNamedParam(t *testing.T) {
+func TestFindPath(t *testing.T) {
+	m := map[string]*ips{
+		"/a/*rest":  {ips: []string{"rest"}},
+		"/a/b/*all": {ips: []string{"all"}},
+		"/:id":      {ips: []string{"id"}},
+		"/a/b":      {ips: []string{"b"}},
+	}
 	tests := []struct {
 		path   string
-		k      string
-		result bool
+		result string
 	}{
-		{"", "", false},
-		{"oi", "", false},
-		{"", "oi", false},
-		{"oi", "oi", true},
-		{"foo", "bar", false},
-		{"foo", ":bar", true},
+		{"/a/b", "b"},
+		{"/a/b/c", "all"},
+		{"/a/c", "rest"},
+		{"/x", "id"},
 	}
-	for i, test := range tests {
-		if r := matchNamedParam(test.path, test.k); r != test.result {
-			t.Fatal("fail", i, test.path, test.k, test.result)
+	for _, test := range tests {
+		// Always the same result.
+		for i := 0; i < 50; i++ {
+			p := findPath(m, test.path)
+			if p == nil || p.ips[0] != test.result {
+				t.Fatal("wrong pattern", test.path, p)
+			}
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	lbs := []LoadBalance{
+		NewRoundRobin(),
+		NewWeightedRoundRobin(),
+		NewLeastConnections(),
+		NewRandom(),
+		NewP2C(),
+		NewConsistentHash(nil, 0),
+		NewSlowStart(NewOutlierDetection(NewRoundRobin(), nil), time.Second),
+	}
+	for _, lb := range lbs {
+		lb.AddAddrs("GET", "/", "10.0.0.1")
+		c := clone(lb)
+		if c == lb {
+			t.Fatalf("%T not cloned", lb)
+		}
+		if fmt.Sprintf("%T", c) != fmt.Sprintf("%T", lb) {
+			t.Fatalf("wrong type %T, %T", c, lb)
+		}
+		if dst := c.Next("GET", "/"); dst != "" {
+			t.Fatalf("%T clone not empty", lb)
 		}
 	}
 }
@@ -0,0 +1,115 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const ctxPattern string = "proxyroutepattern"
+
+// Pattern inserts in the context the pattern of the route that matched the
+// request, so Balance uses the servers of that route instead of searching the
+// pattern of the path.
+func Pattern(pattern string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		handler(w, req.WithContext(context.WithValue(req.Context(), ctxPattern, pattern)))
+	}
+}
+
+// routePattern returns the pattern of the route of the request.
+func routePattern(req *http.Request) (string, bool) {
+	pattern, ok := req.Context().Value(ctxPattern).(string)
+	return pattern, ok && pattern != ""
+}
+
+// Kinds of the segments of a pattern, in the order of precedence.
+const (
+	segStatic = iota
+	// segPrefixed is a named parameter after a static prefix, like user_:name.
+	segPrefixed
+	segParam
+	segCatchAll
+)
+
+func segKind(seg string) int {
+	switch {
+	case strings.HasPrefix(seg, "*"):
+		return segCatchAll
+	case strings.HasPrefix(seg, ":"):
+		return segParam
+	case strings.Contains(seg, ":"):
+		return segPrefixed
+	}
+	return segStatic
+}
+
+// matchPattern returns true if path matches the pattern of httprouter. A named
+// parameter matches one non empty segment and the catch all, only in the end,
+// matches the rest of the path.
+func matchPattern(pattern, path string) bool {
+	if pattern == path {
+		return true
+	}
+	if pattern == "" || path == "" {
+		return false
+	}
+	ps := strings.Split(pattern, "/")
+	segs := strings.Split(path, "/")
+	for i, p := range ps {
+		kind := segKind(p)
+		if kind == segCatchAll {
+			return i == len(ps)-1 && i < len(segs)
+		}
+		if i >= len(segs) {
+			return false
+		}
+		s := segs[i]
+		switch kind {
+		case segStatic:
+			if p != s {
+				return false
+			}
+		case segParam:
+			if s == "" {
+				return false
+			}
+		case segPrefixed:
+			prefix := p[:strings.Index(p, ":")]
+			if !strings.HasPrefix(s, prefix) || len(s) == len(prefix) {
+				return false
+			}
+		}
+	}
+	return len(ps) == len(segs)
+}
+
+// lessPattern returns true if the pattern a has precedence over b. The first
+// segment of different kind decides, static before named parameter before
+// catch all. Ties are in lexical order.
+func lessPattern(a, b string) bool {
+	as := strings.Split(a, "/")
+	bs := strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		ka, kb := segKind(as[i]), segKind(bs[i])
+		if ka != kb {
+			return ka < kb
+		}
+	}
+	if len(as) != len(bs) {
+		return len(as) > len(bs)
+	}
+	return a < b
+}
+
+// sortPatterns sorts the patterns by precedence.
+func sortPatterns(patterns []string) {
+	sort.Slice(patterns, func(i, j int) bool {
+		return lessPattern(patterns[i], patterns[j])
+	})
+}
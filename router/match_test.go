@@ -0,0 +1,189 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		result  bool
+	}{
+		{"", "", true},
+		{"/", "/", true},
+		{"/", "", false},
+		{"", "/", false},
+		{"/oi", "/oi", true},
+		{"/foo", "/bar", false},
+		{"/foo", "/foo/", false},
+		{"/:id", "/bar", true},
+		{"/:id", "/", false},
+		{"/a/:id", "/a/x", true},
+		{"/a/:id", "/a/x/y", false},
+		{"/a/:id", "/a/", false},
+		{"/a/:id/b", "/a/x/b", true},
+		{"/a/:id/b", "/a/x/c", false},
+		{"/user_:name", "/user_joe", true},
+		{"/user_:name", "/user_", false},
+		{"/user_:name", "/admin", false},
+		{"/src/*file", "/src/", true},
+		{"/src/*file", "/src/a/b/c", true},
+		{"/src/*file", "/src", false},
+		{"/src/*file", "/other/a", false},
+		{"/*all", "/", true},
+		{"/*all", "/a/b", true},
+		{"/a/*rest/b", "/a/x/b", false},
+	}
+	for _, test := range tests {
+		if r := matchPattern(test.pattern, test.path); r != test.result {
+			t.Fatal("wrong match", test.pattern, test.path, r)
+		}
+	}
+}
+
+func TestLessPattern(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"/a/b", "/a/:id"},
+		{"/a/:id", "/a/*rest"},
+		{"/a/b", "/a/*rest"},
+		{"/user_:name", "/:name"},
+		{"/a/:id/b", "/a/*rest"},
+		{"/a/:id/b", "/a/:id"},
+		{"/a", "/b"},
+		{"/a/b/*all", "/a/*rest"},
+	}
+	for _, test := range tests {
+		if !lessPattern(test.a, test.b) {
+			t.Fatal("wrong precedence", test.a, test.b)
+		}
+		if lessPattern(test.b, test.a) {
+			t.Fatal("wrong precedence", test.b, test.a)
+		}
+	}
+}
+
+func TestBalancePattern(t *testing.T) {
+	rr := NewRoundRobin()
+	rr.AddAddrs("GET", "/a/:id", "id")
+	rr.AddAddrs("GET", "/a/*rest", "rest")
+	var dst string
+	h := Balance(rr, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		dst = r.Context().Value(ctxName).(string)
+	})
+	r, err := http.NewRequest("GET", "http://localhost/a/x", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responsewriter.Handler(h)(httptest.NewRecorder(), r)
+	if dst != "id" {
+		t.Fatal("wrong server", dst)
+	}
+	// The router matched the catch all.
+	Pattern("/a/*rest", responsewriter.Handler(h))(httptest.NewRecorder(), r)
+	if dst != "rest" {
+		t.Fatal("wrong server", dst)
+	}
+}
+
+// Random patterns and paths, in the place of a fuzzer.
+
+var fuzzSegs = []string{"a", "b", "ab", ":p", "x_:q"}
+
+func randPattern(rnd *rand.Rand) string {
+	n := rnd.Intn(4)
+	segs := make([]string, 0, n+1)
+	for i := 0; i < n; i++ {
+		segs = append(segs, fuzzSegs[rnd.Intn(len(fuzzSegs))])
+	}
+	if rnd.Intn(3) == 0 {
+		segs = append(segs, "*c")
+	}
+	return "/" + strings.Join(segs, "/")
+}
+
+// randPath returns a path that matches pattern.
+func randPath(rnd *rand.Rand, pattern string) string {
+	ps := strings.Split(pattern, "/")
+	for i, p := range ps {
+		switch segKind(p) {
+		case segParam:
+			ps[i] = fuzzSegs[rnd.Intn(3)]
+		case segPrefixed:
+			ps[i] = "x_" + fuzzSegs[rnd.Intn(3)]
+		case segCatchAll:
+			tail := make([]string, rnd.Intn(3))
+			for j := range tail {
+				tail[j] = fuzzSegs[rnd.Intn(3)]
+			}
+			ps[i] = strings.Join(tail, "/")
+		}
+	}
+	return strings.Join(ps, "/")
+}
+
+func TestMatchFuzz(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 5000; i++ {
+		pattern := randPattern(rnd)
+		path := randPath(rnd, pattern)
+		if !matchPattern(pattern, path) {
+			t.Fatal("path doesn't match its pattern", pattern, path)
+		}
+	}
+}
+
+func TestFindPathFuzz(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 500; i++ {
+		m := make(map[string]*ips)
+		patterns := make([]string, 0)
+		for j := 0; j < 1+rnd.Intn(6); j++ {
+			p := randPattern(rnd)
+			if _, found := m[p]; found {
+				continue
+			}
+			m[p] = &ips{ips: []string{p}}
+			patterns = append(patterns, p)
+		}
+		sortPatterns(patterns)
+		for j := 0; j < 20; j++ {
+			path := randPath(rnd, patterns[rnd.Intn(len(patterns))])
+			p := findPath(m, path)
+			if p == nil {
+				t.Fatal("no pattern found", path, patterns)
+			}
+			found := p.ips[0]
+			if !matchPattern(found, path) && found != path {
+				t.Fatal("wrong pattern", found, path)
+			}
+			// No pattern with more precedence matches.
+			for _, other := range patterns {
+				if other == found {
+					break
+				}
+				if matchPattern(other, path) && other != path {
+					t.Fatal("pattern with precedence skipped", other, found, path)
+				}
+			}
+			// Deterministic.
+			for k := 0; k < 5; k++ {
+				if again := findPath(m, path); again != p {
+					t.Fatal("different pattern", again.ips[0], found, path)
+				}
+			}
+		}
+	}
+}
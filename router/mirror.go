@@ -0,0 +1,170 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+	log "github.com/fcavani/slog"
+)
+
+// MirrorPolicy configures the mirroring of the requests of a route to the
+// shadow servers, the servers of the route registered with the tag Tag. The
+// shadow servers only receive the copies and their responses are discarded.
+type MirrorPolicy struct {
+	// Tag of the shadow servers.
+	Tag string
+	// Percent of the requests mirrored, between 0 and 100. Nil is 100, zero
+	// mirrors no request.
+	Percent *float64 `json:",omitempty"`
+	// MaxBodySize is the max size of the request body that is buffered to be
+	// mirrored. Requests with bigger bodies aren't mirrored. Defaults to
+	// BodyLimitSize.
+	MaxBodySize int64
+}
+
+func (mp MirrorPolicy) withDefaults() *MirrorPolicy {
+	percent := 100.0
+	if mp.Percent != nil {
+		percent = *mp.Percent
+	}
+	mp.Percent = &percent
+	if mp.MaxBodySize <= 0 {
+		mp.MaxBodySize = BodyLimitSize
+	}
+	return &mp
+}
+
+func (mp *MirrorPolicy) sample() bool {
+	return *mp.Percent >= 100 || rand.Float64()*100 < *mp.Percent
+}
+
+// MirrorStats are the results of the mirrored requests of a route.
+type MirrorStats struct {
+	// Requests is the number of requests mirrored.
+	Requests int64
+	// Skipped is the number of requests sampled but not mirrored because the
+	// body was too big.
+	Skipped int64
+	// Codes counts the responses of the shadow servers by status code.
+	Codes map[int]int64
+	// P50 and P99 are the latency percentiles of the shadow servers. Zero while
+	// there isn't enough samples.
+	P50 time.Duration
+	P99 time.Duration
+}
+
+// mirror sends the copies of the requests to the shadow servers.
+type mirror struct {
+	mp      *MirrorPolicy
+	shadow  responsewriter.HandlerFunc
+	lat     *latencies
+	lck     sync.Mutex
+	results MirrorStats
+}
+
+func newMirror(policy *MirrorPolicy, shadow responsewriter.HandlerFunc) *mirror {
+	return &mirror{
+		mp:     policy.withDefaults(),
+		shadow: shadow,
+		lat:    newLatencies(HedgeSamples),
+		results: MirrorStats{
+			Codes: make(map[int]int64),
+		},
+	}
+}
+
+// Mirror sends a copy of a sample of the requests to shadow, in background, and
+// the request to handler. The response of shadow is discarded. Use Balance in
+// shadow to send the copies to the shadow servers.
+func Mirror(policy *MirrorPolicy, shadow, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	return newMirror(policy, shadow).handler(handler)
+}
+
+// handler returns handler if m is nil.
+func (m *mirror) handler(handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	if m == nil {
+		return handler
+	}
+	return func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		if isUpgrade(req) || !m.mp.sample() {
+			handler(rw, req)
+			return
+		}
+
+		var body []byte
+		if req.Body != nil && req.Body != http.NoBody {
+			buf, err := ioutil.ReadAll(io.LimitReader(req.Body, m.mp.MaxBodySize+1))
+			if err != nil {
+				log.Tag("router", "mirror").Error("Can't read the body: ", err)
+			}
+			if err != nil || int64(len(buf)) > m.mp.MaxBodySize {
+				// Body too big to buffer, don't mirror the request.
+				m.lck.Lock()
+				m.results.Skipped++
+				m.lck.Unlock()
+				req.Body = readCloser{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+				handler(rw, req)
+				return
+			}
+			body = buf
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		// The copy doesn't end with the request.
+		ctx := context.Background()
+		if pattern, ok := routePattern(req); ok {
+			ctx = context.WithValue(ctx, ctxPattern, pattern)
+		}
+		r := req.Clone(ctx)
+		if body != nil {
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			r.ContentLength = int64(len(body))
+		}
+		go m.send(r)
+
+		handler(rw, req)
+	}
+}
+
+// send sends the copy to the shadow servers and records the result.
+func (m *mirror) send(req *http.Request) {
+	w := responsewriter.NewResponseWriter()
+	start := time.Now()
+	m.shadow(w, req)
+	elapsed := time.Since(start)
+	if body := w.TakeBody(); body != nil {
+		body.Close()
+	}
+	code := w.ResponseCode()
+	m.lat.add(elapsed)
+	m.lck.Lock()
+	m.results.Requests++
+	m.results.Codes[code]++
+	m.lck.Unlock()
+	log.Tag("router", "mirror").DebugLevel().Printf("Mirrored request (%v, %v): %v in %v", req.Method, req.URL.Path, code, elapsed)
+}
+
+// stats returns a copy of the results.
+func (m *mirror) stats() *MirrorStats {
+	m.lck.Lock()
+	s := m.results
+	s.Codes = make(map[int]int64, len(m.results.Codes))
+	for code, n := range m.results.Codes {
+		s.Codes[code] = n
+	}
+	m.lck.Unlock()
+	s.P50, _ = m.lat.percentile(50)
+	s.P99, _ = m.lat.percentile(99)
+	return &s
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	HTTPClient = http.DefaultClient
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("primary"))
+	}))
+	defer server.Close()
+	bodies := make(chan string, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies <- string(body)
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("shadow"))
+	}))
+	defer shadow.Close()
+
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	err = r.Register(&Route{
+		Methode: "POST",
+		Router:  DefaultRouter,
+		Path:    "/mirror",
+		RedirTo: server.URL,
+		Mirror:  &MirrorPolicy{Tag: "shadow", MaxBodySize: 5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Register(&Route{
+		Methode: "POST",
+		Router:  DefaultRouter,
+		Path:    "/mirror",
+		RedirTo: shadow.URL,
+		Tag:     "shadow",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contains(routeLB(r, DefaultRouter, "POST", "/mirror"), "POST", "/mirror", shadow.URL) {
+		t.Fatal("shadow server in the load balance")
+	}
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "http://localhost/en/mirror", strings.NewReader("hello"))
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != "primary" {
+			t.Fatal("wrong response", w.Code, w.Body.String())
+		}
+		select {
+		case body := <-bodies:
+			if body != "hello" {
+				t.Fatal("wrong body", body)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("request not mirrored")
+		}
+	}
+
+	// Body too big.
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "http://localhost/en/mirror", strings.NewReader("hello world"))
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("wrong response", w.Code)
+	}
+
+	var stats *MirrorStats
+	for i := 0; i < 100; i++ {
+		routes, err := r.Get(DefaultRouter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, route := range routes {
+			if route.Path == "/mirror" {
+				stats = route.MirrorStats
+			}
+		}
+		if stats != nil && stats.Requests == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats == nil {
+		t.Fatal("no mirror stats")
+	}
+	if stats.Requests != 3 || stats.Codes[http.StatusTeapot] != 3 || stats.Skipped != 1 {
+		t.Fatalf("wrong stats %#v", stats)
+	}
+	select {
+	case body := <-bodies:
+		t.Fatal("body too big mirrored", body)
+	default:
+	}
+}
+
+func TestMirrorPercent(t *testing.T) {
+	mp := (&MirrorPolicy{Tag: "shadow", Percent: percent(10)}).withDefaults()
+	n := 0
+	for i := 0; i < 10000; i++ {
+		if mp.sample() {
+			n++
+		}
+	}
+	if n < 700 || n > 1300 {
+		t.Fatal("wrong sample", n)
+	}
+	mp = (&MirrorPolicy{Tag: "shadow"}).withDefaults()
+	for i := 0; i < 100; i++ {
+		if !mp.sample() {
+			t.Fatal("request not sampled")
+		}
+	}
+	// Zero pauses the mirroring.
+	mp = (&MirrorPolicy{Tag: "shadow", Percent: percent(0)}).withDefaults()
+	for i := 0; i < 100; i++ {
+		if mp.sample() {
+			t.Fatal("request sampled")
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+// openAPI describes the control API v1. Keep it in sync with api.go.
+const openAPI = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "droute control API",
+    "version": "1"
+  },
+  "servers": [{"url": "/_router/v1"}],
+  "security": [{}, {"bearer": []}],
+  "paths": {
+    "/openapi.json": {
+      "get": {
+        "summary": "This document.",
+        "responses": {
+          "200": {"description": "The OpenAPI document.", "content": {"application/json": {}}}
+        }
+      }
+    },
+    "/routers": {
+      "get": {
+        "summary": "List the routers.",
+        "responses": {
+          "200": {
+            "description": "The names of the routers.",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"type": "string"}}}}
+          },
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/watch": {
+      "get": {
+        "summary": "Stream the changes in the routes.",
+        "description": "Server-sent events if the client accepts text/event-stream, newline delimited json otherwise. Empty lines and comments are keep alive messages.",
+        "parameters": [
+          {"name": "router", "in": "query", "description": "Only the events of the router.", "schema": {"type": "string"}},
+          {"name": "since", "in": "query", "description": "Resume after the revision, the header Last-Event-ID is used too. 410 if the events after it are lost, like after a restart of the router.", "schema": {"type": "integer", "format": "int64"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "The events.",
+            "headers": {"X-Revision": {"description": "The revision when the watch started.", "schema": {"type": "integer", "format": "int64"}}},
+            "content": {
+              "text/event-stream": {"schema": {"type": "string"}},
+              "application/x-ndjson": {"schema": {"$ref": "#/components/schemas/Event"}}
+            }
+          },
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "410": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/routers/{router}/routes": {
+      "parameters": [{"$ref": "#/components/parameters/router"}],
+      "get": {
+        "summary": "List the routes of the router.",
+        "parameters": [
+          {"name": "method", "in": "query", "schema": {"type": "string"}},
+          {"name": "path", "in": "query", "schema": {"type": "string"}},
+          {"name": "tag", "in": "query", "description": "Only the routes with a server with the tag.", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "The routes.",
+            "content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Route"}}}}
+          },
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "post": {
+        "summary": "Add a route or a server to a route.",
+        "description": "The options of an existing route must be the same or empty, otherwise 409.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Route"}}}
+        },
+        "responses": {
+          "201": {
+            "description": "The route was added.",
+            "headers": {"Location": {"schema": {"type": "string"}}},
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Route"}}}
+          },
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "409": {"$ref": "#/components/responses/Problem"},
+          "422": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "delete": {
+        "summary": "Remove all servers of the route.",
+        "parameters": [
+          {"$ref": "#/components/parameters/method"},
+          {"$ref": "#/components/parameters/path"}
+        ],
+        "responses": {
+          "204": {"description": "The route was removed."},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/routers/{router}/routes/backends": {
+      "parameters": [
+        {"$ref": "#/components/parameters/router"},
+        {"$ref": "#/components/parameters/method"},
+        {"$ref": "#/components/parameters/path"}
+      ],
+      "post": {
+        "summary": "Add a server to an existing route.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Backend"}}}
+        },
+        "responses": {
+          "201": {
+            "description": "The server was added.",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Backend"}}}
+          },
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "422": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "patch": {
+        "summary": "Change the weight, renew the lease or drain a server.",
+        "parameters": [{"$ref": "#/components/parameters/addr"}],
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/BackendPatch"}}}
+        },
+        "responses": {
+          "204": {"description": "The server was changed."},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "422": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "delete": {
+        "summary": "Remove a server from the route.",
+        "parameters": [{"$ref": "#/components/parameters/addr"}],
+        "responses": {
+          "204": {"description": "The server was removed."},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/routers/{router}/routes/split": {
+      "parameters": [
+        {"$ref": "#/components/parameters/router"},
+        {"$ref": "#/components/parameters/method"},
+        {"$ref": "#/components/parameters/path"}
+      ],
+      "put": {
+        "summary": "Replace the split policy of the route, null removes it.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/SplitPolicy"}}}
+        },
+        "responses": {
+          "204": {"description": "The split policy was changed."},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "422": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/routers/{router}/routes/rules": {
+      "parameters": [
+        {"$ref": "#/components/parameters/router"},
+        {"$ref": "#/components/parameters/method"},
+        {"$ref": "#/components/parameters/path"}
+      ],
+      "put": {
+        "summary": "Replace the rules of the route, null removes them.",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"type": "array", "nullable": true, "items": {"$ref": "#/components/schemas/Rule"}}}}
+        },
+        "responses": {
+          "204": {"description": "The rules were changed."},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "403": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "422": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "bearer": {"type": "http", "scheme": "bearer"}
+    },
+    "parameters": {
+      "router": {"name": "router", "in": "path", "required": true, "schema": {"type": "string"}},
+      "method": {"name": "method", "in": "query", "required": true, "schema": {"type": "string"}},
+      "path": {"name": "path", "in": "query", "required": true, "schema": {"type": "string"}},
+      "addr": {"name": "addr", "in": "query", "required": true, "schema": {"type": "string"}}
+    },
+    "responses": {
+      "Problem": {
+        "description": "An error, see RFC 7807.",
+        "content": {"application/problem+json": {"schema": {"$ref": "#/components/schemas/Problem"}}}
+      }
+    },
+    "schemas": {
+      "Problem": {
+        "type": "object",
+        "properties": {
+          "type": {"type": "string"},
+          "title": {"type": "string"},
+          "status": {"type": "integer"},
+          "detail": {"type": "string"}
+        }
+      },
+      "Duration": {"type": "integer", "format": "int64", "description": "Nanoseconds."},
+      "Route": {
+        "type": "object",
+        "required": ["Methode", "Path"],
+        "properties": {
+          "Methode": {"type": "string"},
+          "Router": {"type": "string"},
+          "Path": {"type": "string"},
+          "RedirTo": {"type": "string"},
+          "TTL": {"$ref": "#/components/schemas/Duration"},
+          "Weight": {"type": "integer"},
+          "Tag": {"type": "string"},
+          "Timeout": {"$ref": "#/components/schemas/Duration"},
+          "Retry": {"type": "object"},
+          "Hedge": {"type": "object"},
+          "Balancer": {"type": "string"},
+          "StripPrefix": {"type": "string"},
+          "Rewrite": {"type": "string"},
+          "Mirror": {"type": "object"},
+          "Split": {"$ref": "#/components/schemas/SplitPolicy"},
+          "Rules": {"type": "array", "items": {"$ref": "#/components/schemas/Rule"}},
+          "MirrorStats": {"type": "object", "readOnly": true},
+          "Backends": {"type": "array", "readOnly": true, "items": {"$ref": "#/components/schemas/Backend"}}
+        }
+      },
+      "Backend": {
+        "type": "object",
+        "required": ["Addr"],
+        "properties": {
+          "Addr": {"type": "string"},
+          "Health": {"type": "string", "enum": ["unknown", "up", "down", "unverified"], "readOnly": true},
+          "Weight": {"type": "integer"},
+          "Draining": {"type": "boolean", "readOnly": true},
+          "Tag": {"type": "string"},
+          "TTL": {"$ref": "#/components/schemas/Duration"}
+        }
+      },
+      "BackendPatch": {
+        "type": "object",
+        "properties": {
+          "Weight": {"type": "integer"},
+          "TTL": {"$ref": "#/components/schemas/Duration"},
+          "Draining": {"type": "boolean", "enum": [true]}
+        }
+      },
+      "SplitPolicy": {
+        "type": "object",
+        "nullable": true,
+        "properties": {
+          "Weights": {"type": "object", "additionalProperties": {"type": "integer"}},
+          "Overrides": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "properties": {
+                "Header": {"type": "string"},
+                "Cookie": {"type": "string"},
+                "Value": {"type": "string"},
+                "Tag": {"type": "string"}
+              }
+            }
+          }
+        }
+      },
+      "Event": {
+        "type": "object",
+        "properties": {
+          "Revision": {"type": "integer", "format": "int64"},
+          "Type": {"type": "string", "enum": ["add", "remove", "health", "weight"]},
+          "Time": {"type": "string", "format": "date-time"},
+          "Router": {"type": "string"},
+          "Method": {"type": "string"},
+          "Path": {"type": "string"},
+          "Addr": {"type": "string"},
+          "Tag": {"type": "string"},
+          "Weight": {"type": "integer"},
+          "Health": {"type": "string", "enum": ["unknown", "up", "down", "unverified"]}
+        }
+      },
+      "Rule": {
+        "type": "object",
+        "properties": {
+          "Priority": {"type": "integer"},
+          "Headers": {"type": "object", "additionalProperties": {"type": "string"}},
+          "Query": {"type": "object", "additionalProperties": {"type": "string"}},
+          "Cookies": {"type": "object", "additionalProperties": {"type": "string"}},
+          "CIDRs": {"type": "array", "items": {"type": "string"}},
+          "ContentType": {"type": "string"},
+          "Tag": {"type": "string"}
+        }
+      }
+    }
+  }
+}
+`
@@ -0,0 +1,270 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/fcavani/slog"
+)
+
+// OutlierPolicy configures the passive outlier detection.
+type OutlierPolicy struct {
+	// Consecutive is the number of consecutive 5xx responses, including the
+	// connection errors, that ejects the server. Defaults to 5.
+	Consecutive int
+	// BaseEjectionTime is the time the server stays out of the LoadBalance.
+	// It is multiplied by the number of times the server was ejected.
+	// Defaults to 30s.
+	BaseEjectionTime time.Duration
+	// MaxEjectionTime caps the ejection time. Defaults to 300s.
+	MaxEjectionTime time.Duration
+	// MaxEjectionPercent is the max percent of the servers ejected at the same
+	// time. At least one server is ejected if the pool has more than one.
+	// Defaults to 10.
+	MaxEjectionPercent int
+}
+
+func (op OutlierPolicy) withDefaults() *OutlierPolicy {
+	if op.Consecutive <= 0 {
+		op.Consecutive = 5
+	}
+	if op.BaseEjectionTime <= 0 {
+		op.BaseEjectionTime = 30 * time.Second
+	}
+	if op.MaxEjectionTime <= 0 {
+		op.MaxEjectionTime = 300 * time.Second
+	}
+	if op.MaxEjectionTime < op.BaseEjectionTime {
+		op.MaxEjectionTime = op.BaseEjectionTime
+	}
+	if op.MaxEjectionPercent <= 0 {
+		op.MaxEjectionPercent = 10
+	}
+	return &op
+}
+
+// pool is one route of the LoadBalance.
+type pool struct {
+	method string
+	path   string
+}
+
+// outlierHost is the state of one server.
+type outlierHost struct {
+	// pools are the routes of the server with its weight, zero if not set.
+	pools      map[pool]int
+	failures   int
+	ejections  int
+	ejected    bool
+	readmitted time.Time
+}
+
+// OutlierDetection wraps a LoadBalance and ejects the servers that fail
+// consecutive requests. The server is added again to the LoadBalance when the
+// ejection time ends, and each new ejection lasts longer. The readmitted
+// server receives its full share of the requests at once, wrap the
+// LoadBalance with NewSlowStart to ramp it up.
+type OutlierDetection struct {
+	LoadBalance
+	op    *OutlierPolicy
+	hosts map[string]*outlierHost
+	lck   sync.Mutex
+}
+
+// NewOutlierDetection adds the outlier detection to lb. op can be nil for the
+// defaults.
+func NewOutlierDetection(lb LoadBalance, op *OutlierPolicy) *OutlierDetection {
+	if op == nil {
+		op = &OutlierPolicy{}
+	}
+	return &OutlierDetection{
+		LoadBalance: lb,
+		op:          op.withDefaults(),
+		hosts:       make(map[string]*outlierHost),
+	}
+}
+
+// Clone returns a new outlier detection with the same policy for a clone of
+// the LoadBalance.
+func (od *OutlierDetection) Clone() LoadBalance {
+	return NewOutlierDetection(clone(od.LoadBalance), od.op)
+}
+
+// AddAddrs adds ip to the LoadBalance if it isn't ejected.
+func (od *OutlierDetection) AddAddrs(method, path, ip string) {
+	od.lck.Lock()
+	defer od.lck.Unlock()
+	h, found := od.hosts[ip]
+	if !found {
+		h = &outlierHost{pools: make(map[pool]int)}
+		od.hosts[ip] = h
+	}
+	p := pool{method, path}
+	h.pools[p] = h.pools[p]
+	if h.ejected {
+		return
+	}
+	od.LoadBalance.AddAddrs(method, path, ip)
+}
+
+// Remove removes ip from the LoadBalance.
+func (od *OutlierDetection) Remove(method, path, ip string) {
+	od.lck.Lock()
+	defer od.lck.Unlock()
+	if h, found := od.hosts[ip]; found {
+		delete(h.pools, pool{method, path})
+		if len(h.pools) == 0 && !h.ejected {
+			delete(od.hosts, ip)
+		}
+	}
+	od.LoadBalance.Remove(method, path, ip)
+}
+
+// SetWeight sets the weight if the LoadBalance is a Weighter.
+func (od *OutlierDetection) SetWeight(method, path, ip string, weight int) {
+	od.lck.Lock()
+	defer od.lck.Unlock()
+	h, found := od.hosts[ip]
+	if !found {
+		return
+	}
+	p := pool{method, path}
+	if _, found := h.pools[p]; !found {
+		return
+	}
+	h.pools[p] = weight
+	if h.ejected {
+		return
+	}
+	if w, ok := od.LoadBalance.(Weighter); ok {
+		w.SetWeight(method, path, ip, weight)
+	}
+}
+
+// NextRequest uses the NextRequest of the LoadBalance if it has one.
+func (od *OutlierDetection) NextRequest(req *http.Request, method, path string) string {
+	if rlb, ok := od.LoadBalance.(RequestLoadBalance); ok {
+		return rlb.NextRequest(req, method, path)
+	}
+	return od.LoadBalance.Next(method, path)
+}
+
+// Contains returns true if ip is in the LoadBalance.
+func (od *OutlierDetection) Contains(method, path, ip string) bool {
+	return contains(od.LoadBalance, method, path, ip)
+}
+
+func (od *OutlierDetection) addrs(method, path string) []string {
+	if a, ok := od.LoadBalance.(addrser); ok {
+		return a.addrs(method, path)
+	}
+	return nil
+}
+
+// Ejected returns true if ip is ejected.
+func (od *OutlierDetection) Ejected(ip string) bool {
+	od.lck.Lock()
+	defer od.lck.Unlock()
+	h, found := od.hosts[ip]
+	return found && h.ejected
+}
+
+// Report counts the failures of ip and ejects it if needed.
+func (od *OutlierDetection) Report(method, path, ip string, code int) {
+	if r, ok := od.LoadBalance.(Reporter); ok {
+		r.Report(method, path, ip, code)
+	}
+	od.lck.Lock()
+	defer od.lck.Unlock()
+	h, found := od.hosts[ip]
+	if !found || h.ejected {
+		return
+	}
+	if !serverError(code) {
+		h.failures = 0
+		return
+	}
+	h.failures++
+	if h.failures < od.op.Consecutive || !od.canEject() {
+		return
+	}
+	od.eject(ip, h)
+}
+
+// canEject returns true if one more server can be ejected. lck must be
+// locked.
+func (od *OutlierDetection) canEject() bool {
+	members, ejected := 0, 0
+	for _, h := range od.hosts {
+		if h.ejected {
+			ejected++
+		}
+		if h.ejected || len(h.pools) > 0 {
+			members++
+		}
+	}
+	max := members * od.op.MaxEjectionPercent / 100
+	if max < 1 {
+		max = 1
+	}
+	if max >= members {
+		// Never eject all servers.
+		max = members - 1
+	}
+	return ejected < max
+}
+
+// eject removes the server from the LoadBalance. lck must be locked.
+func (od *OutlierDetection) eject(ip string, h *outlierHost) {
+	now := time.Now()
+	if !h.readmitted.IsZero() {
+		// The ejections are forgotten while the server works.
+		h.ejections -= int(now.Sub(h.readmitted) / od.op.BaseEjectionTime)
+		if h.ejections < 0 {
+			h.ejections = 0
+		}
+	}
+	h.ejections++
+	d := od.op.BaseEjectionTime * time.Duration(h.ejections)
+	if d > od.op.MaxEjectionTime {
+		d = od.op.MaxEjectionTime
+	}
+	h.ejected = true
+	h.failures = 0
+	for p := range h.pools {
+		od.LoadBalance.Remove(p.method, p.path, ip)
+	}
+	log.Tag("router", "outlier").Printf("Server %v ejected for %v.", ip, d)
+	time.AfterFunc(d, func() {
+		od.readmit(ip, h)
+	})
+}
+
+// readmit adds the server back to the LoadBalance, a SlowStart warms it up
+// again.
+func (od *OutlierDetection) readmit(ip string, h *outlierHost) {
+	od.lck.Lock()
+	defer od.lck.Unlock()
+	if od.hosts[ip] != h || !h.ejected {
+		return
+	}
+	h.ejected = false
+	h.readmitted = time.Now()
+	if len(h.pools) == 0 {
+		delete(od.hosts, ip)
+		return
+	}
+	w, weighter := od.LoadBalance.(Weighter)
+	for p, weight := range h.pools {
+		od.LoadBalance.AddAddrs(p.method, p.path, ip)
+		if weighter && weight > 0 {
+			w.SetWeight(p.method, p.path, ip, weight)
+		}
+	}
+	log.Tag("router", "outlier").Printf("Server %v readmitted.", ip)
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+func TestOutlierEject(t *testing.T) {
+	od := NewOutlierDetection(NewRoundRobin(), &OutlierPolicy{
+		Consecutive:        2,
+		BaseEjectionTime:   50 * time.Millisecond,
+		MaxEjectionPercent: 50,
+	})
+	od.AddAddrs("GET", "/", "10.0.1.1")
+	od.AddAddrs("GET", "/", "10.0.1.2")
+
+	od.Report("GET", "/", "10.0.1.1", 502)
+	od.Report("GET", "/", "10.0.1.1", 200)
+	od.Report("GET", "/", "10.0.1.1", 500)
+	if od.Ejected("10.0.1.1") {
+		t.Fatal("success didn't reset the failures")
+	}
+	od.Report("GET", "/", "10.0.1.1", 503)
+	if !od.Ejected("10.0.1.1") {
+		t.Fatal("server not ejected")
+	}
+	if od.Contains("GET", "/", "10.0.1.1") {
+		t.Fatal("server still in the load balance")
+	}
+	for i := 0; i < 4; i++ {
+		if dst := od.Next("GET", "/"); dst != "10.0.1.2" {
+			t.Fatal("wrong server", dst)
+		}
+	}
+
+	// Health check adding the server again doesn't readmit it.
+	od.AddAddrs("GET", "/", "10.0.1.1")
+	if od.Contains("GET", "/", "10.0.1.1") {
+		t.Fatal("ejected server added")
+	}
+
+	// The pool can't be ejected entirely.
+	od.Report("GET", "/", "10.0.1.2", 500)
+	od.Report("GET", "/", "10.0.1.2", 500)
+	if od.Ejected("10.0.1.2") {
+		t.Fatal("too many servers ejected")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	if od.Ejected("10.0.1.1") || !od.Contains("GET", "/", "10.0.1.1") {
+		t.Fatal("server not readmitted")
+	}
+}
+
+func TestOutlierEjectionTime(t *testing.T) {
+	od := NewOutlierDetection(NewWeightedRoundRobin(), &OutlierPolicy{
+		Consecutive:      1,
+		BaseEjectionTime: time.Hour,
+		MaxEjectionTime:  90 * time.Minute,
+	})
+	od.AddAddrs("GET", "/", "10.0.1.1")
+	od.SetWeight("GET", "/", "10.0.1.1", 3)
+	od.AddAddrs("GET", "/", "10.0.1.2")
+
+	h := od.hosts["10.0.1.1"]
+	od.Report("GET", "/", "10.0.1.1", 500)
+	if h.ejections != 1 {
+		t.Fatal("wrong ejections", h.ejections)
+	}
+	od.readmit("10.0.1.1", h)
+	if !od.Contains("GET", "/", "10.0.1.1") {
+		t.Fatal("server not readmitted")
+	}
+	if h.pools[pool{"GET", "/"}] != 3 {
+		t.Fatal("weight lost")
+	}
+	od.Report("GET", "/", "10.0.1.1", 500)
+	if h.ejections != 2 {
+		t.Fatal("wrong ejections", h.ejections)
+	}
+
+	// Working for a while the ejections are forgotten.
+	od.readmit("10.0.1.1", h)
+	h.readmitted = time.Now().Add(-3 * time.Hour)
+	od.Report("GET", "/", "10.0.1.1", 500)
+	if h.ejections != 1 {
+		t.Fatal("wrong ejections", h.ejections)
+	}
+}
+
+func TestOutlierReadmitWarmUp(t *testing.T) {
+	ss := NewSlowStart(NewRoundRobin(), time.Hour)
+	od := NewOutlierDetection(ss, &OutlierPolicy{
+		Consecutive:      1,
+		BaseEjectionTime: time.Hour,
+	})
+	od.AddAddrs("GET", "/", "10.0.1.1")
+	od.AddAddrs("GET", "/", "10.0.1.2")
+	ss.added["10.0.1.1"] = time.Now().Add(-2 * time.Hour)
+
+	od.Report("GET", "/", "10.0.1.1", 500)
+	if !od.Ejected("10.0.1.1") {
+		t.Fatal("server not ejected")
+	}
+	od.readmit("10.0.1.1", od.hosts["10.0.1.1"])
+	if f := ss.factor("10.0.1.1"); f > float64(SlowStartMinPercent)/100 {
+		t.Fatal("readmitted server didn't warm up", f)
+	}
+}
+
+func TestBalanceReport(t *testing.T) {
+	od := NewOutlierDetection(NewRoundRobin(), &OutlierPolicy{
+		Consecutive:        1,
+		MaxEjectionPercent: 50,
+	})
+	od.AddAddrs("GET", "/", "10.0.1.1")
+	od.AddAddrs("GET", "/", "10.0.1.2")
+	h := Balance(od, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		if r.Context().Value(ctxName).(string) == "10.0.1.1" {
+			rw.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		rw.WriteHeader(http.StatusOK)
+	})
+	r, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		h(responsewriter.NewResponseWriter(), r)
+	}
+	if !od.Ejected("10.0.1.1") {
+		t.Fatal("server not ejected")
+	}
+	if od.Ejected("10.0.1.2") {
+		t.Fatal("healthy server ejected")
+	}
+}
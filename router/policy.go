@@ -0,0 +1,187 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+	"github.com/fcavani/e"
+	"github.com/fcavani/text"
+	"gopkg.in/fcavani/httprouter.v2"
+)
+
+// Balancers are the LoadBalance that a route can select by name with
+// Route.Balancer, or a router with SetBalancer. The LoadBalance of the route is
+// wrapped with OutlierDetection.
+var Balancers = map[string]func() LoadBalance{
+	"roundrobin": func() LoadBalance { return NewRoundRobin() },
+	"weighted":   func() LoadBalance { return NewWeightedRoundRobin() },
+	"leastconn":  func() LoadBalance { return NewLeastConnections() },
+	"random":     func() LoadBalance { return NewRandom() },
+	"p2c":        func() LoadBalance { return NewP2C() },
+	"hash":       func() LoadBalance { return NewConsistentHash(HashRemoteIP(), 0) },
+}
+
+// checkPolicy validates the proxy options of the route.
+func checkPolicy(route *Route) error {
+	if route.Timeout < 0 {
+		return e.New("invalid timeout")
+	}
+	if route.Balancer != "" {
+		if _, found := Balancers[route.Balancer]; !found {
+			return e.New("invalid balancer %v", route.Balancer)
+		}
+	}
+	if route.StripPrefix != "" && !strings.HasPrefix(route.StripPrefix, "/") {
+		return e.New("invalid strip prefix")
+	}
+	if route.Rewrite != "" && !strings.HasPrefix(route.Rewrite, "/") {
+		return e.New("invalid rewrite")
+	}
+	if p := route.Retry; p != nil {
+		if p.Attempts < 0 || p.MaxBodySize < 0 || p.Backoff < 0 || p.MaxBackoff < 0 || p.BudgetMin < 0 {
+			return e.New("invalid retry policy")
+		}
+		if p.Budget < 0 || p.Budget > 1 {
+			return e.New("invalid retry budget")
+		}
+		err := checkMethods(p.Methods)
+		if err != nil {
+			return e.Push(err, "invalid retry policy")
+		}
+	}
+	if mp := route.Mirror; mp != nil {
+		if mp.Tag == "" {
+			return e.New("mirror policy without tag")
+		}
+		if (mp.Percent != nil && (*mp.Percent < 0 || *mp.Percent > 100)) || mp.MaxBodySize < 0 {
+			return e.New("invalid mirror policy")
+		}
+	}
+	err := checkSplit(route.Split)
+	if err != nil {
+		return err
+	}
+	_, err = compileRules(route.Rules)
+	if err != nil {
+		return err
+	}
+	if hp := route.Hedge; hp != nil {
+		if hp.Delay < 0 || hp.MaxHedges < 0 || hp.Percentile < 0 || hp.Percentile > 100 {
+			return e.New("invalid hedge policy")
+		}
+		err := checkMethods(hp.Methods)
+		if err != nil {
+			return e.Push(err, "invalid hedge policy")
+		}
+	}
+	return nil
+}
+
+func checkMethods(methods []string) error {
+	for _, m := range methods {
+		err := text.CheckLettersNumber(m, 3, 20)
+		if err != nil {
+			return e.Push(err, "invalid method name")
+		}
+	}
+	return nil
+}
+
+// SetBalancer sets the LoadBalance, by name from Balancers, of the routes of
+// the router routerName added after the call. Empty name uses a clone of the
+// LoadBalance passed to Start.
+func (r *Router) SetBalancer(routerName, name string) error {
+	if _, found := r.routers[routerName]; !found {
+		return e.New("router not found")
+	}
+	if _, found := Balancers[name]; !found && name != "" {
+		return e.New("invalid balancer %v", name)
+	}
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+	r.balancers[routerName] = name
+	return nil
+}
+
+// balancer returns a new LoadBalance for the route. tableLck must be locked.
+func (r *Router) balancer(route *Route) LoadBalance {
+	name := route.Balancer
+	if name == "" {
+		name = r.balancers[route.Router]
+	}
+	if name == "" {
+		return clone(r.lb)
+	}
+	return NewOutlierDetection(Balancers[name](), nil)
+}
+
+// checkOptions returns an error if the proxy options set in route aren't the
+// options opts of the existing route. The empty options are ignored.
+func (r *Router) checkOptions(opts, route *Route) error {
+	balancer := opts.Balancer
+	if balancer == "" {
+		balancer = r.balancers[opts.Router]
+	}
+	switch {
+	case route.Timeout != 0 && route.Timeout != opts.Timeout:
+		return e.New("route exists with other timeout")
+	case route.Retry != nil && !reflect.DeepEqual(route.Retry, opts.Retry):
+		return e.New("route exists with other retry policy")
+	case route.Hedge != nil && !reflect.DeepEqual(route.Hedge, opts.Hedge):
+		return e.New("route exists with other hedge policy")
+	case route.Balancer != "" && route.Balancer != balancer:
+		return e.New("route exists with other balancer")
+	case route.StripPrefix != "" && route.StripPrefix != opts.StripPrefix:
+		return e.New("route exists with other strip prefix")
+	case route.Rewrite != "" && route.Rewrite != opts.Rewrite:
+		return e.New("route exists with other rewrite")
+	case route.Mirror != nil && !reflect.DeepEqual(route.Mirror, opts.Mirror):
+		return e.New("route exists with other mirror policy")
+	}
+	return nil
+}
+
+// timeout returns the proxy timeout of the route.
+func (r *Router) timeout(route *Route) time.Duration {
+	if route.Timeout > 0 {
+		return route.Timeout
+	}
+	return r.proxyTimeout
+}
+
+// Rewrite removes the prefix strip from the path of the request and adds the
+// prefix to it before sending it to the server. The language prefix stays.
+func Rewrite(strip, prefix string, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	if strip == "" && prefix == "" {
+		return handler
+	}
+	prefix = strings.TrimSuffix(prefix, "/")
+	return func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		path := req.URL.Path
+		lang := ""
+		if l := httprouter.ContentLang(req); l != "" && (path == "/"+l || strings.HasPrefix(path, "/"+l+"/")) {
+			lang = "/" + l
+			path = path[len(lang):]
+		}
+		if strip != "" && (path == strip || strings.HasPrefix(path, strings.TrimSuffix(strip, "/")+"/")) {
+			path = strings.TrimPrefix(path, strings.TrimSuffix(strip, "/"))
+		}
+		path = prefix + path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		u := *req.URL
+		u.Path = lang + path
+		u.RawPath = ""
+		req = req.WithContext(req.Context())
+		req.URL = &u
+		handler(rw, req)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+	"github.com/fcavani/e"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	tests := []struct {
+		route *Route
+		err   string
+	}{
+		{&Route{}, ""},
+		{&Route{Timeout: time.Second, Balancer: "leastconn", StripPrefix: "/api", Rewrite: "/v2"}, ""},
+		{&Route{Timeout: -1}, "invalid timeout"},
+		{&Route{Balancer: "blurf"}, "invalid balancer"},
+		{&Route{StripPrefix: "api"}, "invalid strip prefix"},
+		{&Route{Rewrite: "v2"}, "invalid rewrite"},
+		{&Route{Retry: &RetryPolicy{Attempts: 3, Methods: []string{"GET"}}}, ""},
+		{&Route{Retry: &RetryPolicy{Attempts: -1}}, "invalid retry policy"},
+		{&Route{Retry: &RetryPolicy{Budget: 2}}, "invalid retry budget"},
+		{&Route{Retry: &RetryPolicy{Methods: []string{"G"}}}, "invalid method name"},
+		{&Route{Hedge: &HedgePolicy{Percentile: 101}}, "invalid hedge policy"},
+		{&Route{Mirror: &MirrorPolicy{}}, "mirror policy without tag"},
+		{&Route{Mirror: &MirrorPolicy{Tag: "v2", Percent: percent(101)}}, "invalid mirror policy"},
+		{&Route{Mirror: &MirrorPolicy{Tag: "v2", Percent: percent(0)}}, ""},
+	}
+	for i, test := range tests {
+		err := checkPolicy(test.route)
+		if test.err == "" && err != nil {
+			t.Fatal(i, err)
+		}
+		if test.err != "" && (err == nil || !e.Contains(err, test.err)) {
+			t.Fatal(i, "wrong error", err)
+		}
+	}
+}
+
+func percent(p float64) *float64 {
+	return &p
+}
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		strip, prefix, path, result string
+	}{
+		{"", "", "/api/users", "/api/users"},
+		{"/api", "", "/api/users", "/users"},
+		{"/api/", "", "/api/users", "/users"},
+		{"/api", "", "/api", "/"},
+		{"/api", "", "/apiary", "/apiary"},
+		{"/api", "/v2", "/api/users", "/v2/users"},
+		{"", "/v2/", "/users", "/v2/users"},
+	}
+	for _, test := range tests {
+		var path string
+		h := Rewrite(test.strip, test.prefix, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+		})
+		r, err := http.NewRequest("GET", "http://localhost"+test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h(responsewriter.NewResponseWriter(), r)
+		if path != test.result {
+			t.Fatal("wrong path", test.strip, test.prefix, test.path, path)
+		}
+		if r.URL.Path != test.path {
+			t.Fatal("request changed", r.URL.Path)
+		}
+	}
+}
+
+func TestRoutePolicy(t *testing.T) {
+	HTTPClient = http.DefaultClient
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/en/v2/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	lb := NewRoundRobin()
+	r := &Router{}
+	err := r.Start(NewRouters(), lb, time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	err = r.Register(&Route{
+		Methode:  "GET",
+		Router:   DefaultRouter,
+		Path:     "/policy/*rest",
+		RedirTo:  server.URL,
+		Balancer: "blurf",
+	})
+	if err == nil || !e.Contains(err, "invalid balancer") {
+		t.Fatal("wrong error", err)
+	}
+
+	route := &Route{
+		Methode:     "GET",
+		Router:      DefaultRouter,
+		Path:        "/policy/*rest",
+		RedirTo:     server.URL,
+		Timeout:     50 * time.Millisecond,
+		Retry:       &RetryPolicy{Attempts: 1},
+		Balancer:    "leastconn",
+		StripPrefix: "/policy",
+		Rewrite:     "/v2",
+	}
+	err = r.Register(route)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lb.Contains("GET", "/policy/*rest", server.URL) {
+		t.Fatal("server in the router load balance")
+	}
+	if _, ok := routeLB(r, DefaultRouter, "GET", "/policy/*rest").(*OutlierDetection); !ok {
+		t.Fatal("wrong load balance")
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/en/policy/users", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "/en/v2/users" {
+		t.Fatal("wrong response", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/en/policy/slow", nil))
+	if w.Code != http.StatusRequestTimeout {
+		t.Fatal("wrong response code", w.Code)
+	}
+
+	routes, err := r.Get(DefaultRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got *Route
+	for _, rt := range routes {
+		if rt.Path == "/policy/*rest" {
+			got = rt
+		}
+	}
+	if got == nil {
+		t.Fatal("route not found")
+	}
+	if got.Timeout != route.Timeout || got.Retry == nil || got.Retry.Attempts != 1 ||
+		got.Balancer != route.Balancer || got.StripPrefix != route.StripPrefix || got.Rewrite != route.Rewrite {
+		t.Fatalf("wrong options %#v", got)
+	}
+}
@@ -5,6 +5,7 @@
 package router
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"io"
@@ -50,6 +51,12 @@ func ConfigProxyHTTPClient(ca string, insecure bool) error {
 	return nil
 }
 
+// serverError returns true if code is a server error. Proxy answers 500 if it
+// can't reach the server, so it's a failure of the server too.
+func serverError(code int) bool {
+	return code >= 500 && code < 600
+}
+
 // Proxy forward the requests coming on path to dst url.
 func Proxy(path string, timeout time.Duration) responsewriter.HandlerFunc {
 	return func(w *responsewriter.ResponseWriter, r *http.Request) {
@@ -84,52 +91,129 @@ func Proxy(path string, timeout time.Duration) responsewriter.HandlerFunc {
 		r.URL.Scheme = parsed.Scheme
 		r.RequestURI = ""
 		r.Header.Add("X-Dst-Serv", dst)
+		cleanRequestHeaders(r.Header)
 
-		signal := make(chan error)
-
-		go func() {
-			resp, err := HTTPClient.Do(r)
-			if err != nil {
-				err = e.Push(err, e.New("can't forward the request."))
-				log.Tag("router", "server", "proxy").Error(e.Trace(err))
-				signal <- err
-				return
-			}
-			if resp.Body != nil {
-				defer resp.Body.Close()
-			}
+		if isUpgrade(r) {
+			proxyUpgrade(w, r, parsed, oldurl, timeout)
+			return
+		}
 
-			headers := w.Header()
-			for k, vals := range resp.Header {
-				for _, val := range vals {
-					headers.Add(k, val)
-				}
-			}
-			w.WriteHeader(resp.StatusCode)
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if w.Stream() {
+			// The timeout only covers the response headers, the body can take
+			// as long as it needs.
+			ctx, cancel = context.WithCancel(r.Context())
+		} else {
+			ctx, cancel = context.WithTimeout(r.Context(), timeout)
+		}
+		r = r.WithContext(ctx)
 
-			if resp.Body == nil {
-				log.Tag("router", "server", "proxy").Printf("%v => %v, %v bytes, %v (%v)", oldurl, r.URL, 0, r.Method, resp.StatusCode)
-				signal <- nil
-				return
-			}
+		type result struct {
+			resp *http.Response
+			err  error
+		}
+		signal := make(chan result)
+		abort := make(chan struct{})
 
-			n, err := io.Copy(w, resp.Body)
-			if err != nil {
-				log.Tag("router", "server", "proxy").Error("Can't copy the buffer: ", err)
-				signal <- err
-				return
+		client := HTTPClient
+		go func() {
+			resp, err := client.Do(r)
+			select {
+			case signal <- result{resp, err}:
+			case <-abort:
+				if err == nil && resp.Body != nil {
+					resp.Body.Close()
+				}
 			}
-			log.Tag("router", "server", "proxy").Printf("%v => %v, %v bytes, %v (%v)", oldurl, r.URL, n, r.Method, resp.StatusCode)
-			signal <- nil
 		}()
 
+		var resp *http.Response
 		select {
-		case err := <-signal:
-			if err != nil {
+		case res := <-signal:
+			if res.err != nil {
+				cancel()
+				err = e.Push(res.err, e.New("can't forward the request."))
+				log.Tag("router", "server", "proxy").Error(e.Trace(err))
 				errhandler.ErrHandler(w, http.StatusInternalServerError, err)
+				return
 			}
+			resp = res.resp
 		case <-time.After(timeout):
+			close(abort)
+			cancel()
 			errhandler.ErrHandler(w, http.StatusRequestTimeout, e.New("proxy request timeout"))
+			return
 		}
+
+		removeHopHeaders(resp.Header)
+		headers := w.Header()
+		for k, vals := range resp.Header {
+			for _, val := range vals {
+				headers.Add(k, val)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+
+		if resp.Body == nil {
+			cancel()
+			log.Tag("router", "server", "proxy").Printf("%v => %v, %v bytes, %v (%v)", oldurl, r.URL, 0, r.Method, resp.StatusCode)
+			return
+		}
+
+		if w.Stream() {
+			// The status code is already known by the handlers up in the
+			// chain, the body is only read when the response is sent to the
+			// client.
+			w.SetBody(&proxyBody{
+				ReadCloser: resp.Body,
+				cancel:     cancel,
+				oldurl:     oldurl,
+				req:        r,
+				code:       resp.StatusCode,
+			})
+			return
+		}
+
+		defer cancel()
+		defer resp.Body.Close()
+		n, err := io.Copy(w, resp.Body)
+		if err != nil {
+			log.Tag("router", "server", "proxy").Error("Can't copy the buffer: ", err)
+			if ctx.Err() == context.DeadlineExceeded {
+				errhandler.ErrHandler(w, http.StatusRequestTimeout, e.New("proxy request timeout"))
+				return
+			}
+			errhandler.ErrHandler(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Tag("router", "server", "proxy").Printf("%v => %v, %v bytes, %v (%v)", oldurl, r.URL, n, r.Method, resp.StatusCode)
 	}
 }
+
+// proxyBody is the body of the server response streamed to the client.
+type proxyBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	oldurl string
+	req    *http.Request
+	code   int
+	n      int64
+}
+
+func (pb *proxyBody) Read(p []byte) (int, error) {
+	n, err := pb.ReadCloser.Read(p)
+	pb.n += int64(n)
+	return n, err
+}
+
+// Close closes the server response body and releases the request.
+func (pb *proxyBody) Close() error {
+	err := pb.ReadCloser.Close()
+	pb.cancel()
+	log.Tag("router", "server", "proxy").Printf("%v => %v, %v bytes, %v (%v)", pb.oldurl, pb.req.URL, pb.n, pb.req.Method, pb.code)
+	if err != nil {
+		return e.Forward(err)
+	}
+	return nil
+}
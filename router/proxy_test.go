@@ -8,7 +8,9 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -179,6 +181,84 @@ func TestTimeout(t *testing.T) {
 	}
 }
 
+func TestProxyStream(t *testing.T) {
+	HTTPClient = http.DefaultClient
+	next := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		// Wait more than the proxy timeout.
+		time.Sleep(400 * time.Millisecond)
+		<-next
+		io.WriteString(w, "data: 2\n\n")
+	}))
+	defer server.Close()
+
+	rd := NewRedirDst(server.URL)
+	front := httptest.NewServer(responsewriter.Handler(Balance(rd, Proxy("", 300*time.Millisecond))))
+	defer front.Close()
+
+	resp, err := http.Get(front.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal("response code is wrong", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatal("wrong content type", ct)
+	}
+	buf := make([]byte, 9)
+	_, err = io.ReadFull(resp.Body, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "data: 1\n\n" {
+		t.Fatal("wrong event", string(buf))
+	}
+	close(next)
+	_, err = io.ReadFull(resp.Body, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "data: 2\n\n" {
+		t.Fatal("wrong event", string(buf))
+	}
+}
+
+func TestProxyStreamCode(t *testing.T) {
+	HTTPClient = &http.Client{
+		Transport: &transport{},
+	}
+	rd := NewRedirDst("10.0.0.1")
+	h := Balance(rd, Proxy("", 300*time.Millisecond))
+
+	w := responsewriter.NewStreamResponseWriter()
+	r, err := http.NewRequest("GET", "http://blurft", bytes.NewBufferString("oi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(w, r)
+	if code := w.ResponseCode(); code != 200 {
+		t.Fatal("response code is wrong", code)
+	}
+	// The body is only read when copied to the client.
+	if w.Len() != 0 {
+		t.Fatal("body was buffered")
+	}
+	dst := httptest.NewRecorder()
+	err = w.Copy(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if str := dst.Body.String(); str != "oi" {
+		t.Fatal("response error", str)
+	}
+}
+
 type errorBuf struct{}
 
 func (eb *errorBuf) Read(p []byte) (int, error) {
@@ -5,20 +5,237 @@
 package router
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/fcavani/droute/responsewriter"
+	log "github.com/fcavani/slog"
 )
 
+const ctxAttempts string = "proxyattempts"
+
+// RetryPolicy configures the retries of the requests that fail with a server
+// error.
+type RetryPolicy struct {
+	// Attempts is the max number of times that the request is sent, including
+	// the first one.
+	Attempts int
+	// Methods are the methods that can be retried. Defaults to the idempotent
+	// methods.
+	Methods []string
+	// MaxBodySize is the max size of the request body that is buffered to be
+	// sent again. Requests with bigger bodies aren't retried. Defaults to
+	// BodyLimitSize.
+	MaxBodySize int64
+	// Backoff is the base delay between the attempts, it doubles on each
+	// attempt up to MaxBackoff. The actual delay is a random value between
+	// zero and the delay. Defaults to 10ms and 1s.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Budget is the ratio of retries to requests allowed for the route, with
+	// a reserve of BudgetMin retries. Defaults to 0.2 and 10.
+	Budget    float64
+	BudgetMin int
+}
+
+// idempotent are the methods retried by default.
+var idempotent = []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"}
+
+func (rp RetryPolicy) withDefaults() *RetryPolicy {
+	if rp.Attempts <= 0 {
+		rp.Attempts = 1
+	}
+	if rp.Methods == nil {
+		rp.Methods = idempotent
+	}
+	if rp.MaxBodySize <= 0 {
+		rp.MaxBodySize = BodyLimitSize
+	}
+	if rp.Backoff <= 0 {
+		rp.Backoff = 10 * time.Millisecond
+	}
+	if rp.MaxBackoff <= 0 {
+		rp.MaxBackoff = time.Second
+	}
+	if rp.Budget <= 0 {
+		rp.Budget = 0.2
+	}
+	if rp.BudgetMin <= 0 {
+		rp.BudgetMin = 10
+	}
+	return &rp
+}
+
+func (rp *RetryPolicy) retryable(method string) bool {
+	for _, m := range rp.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the delay before the attempt, the first one is 1.
+func (rp *RetryPolicy) backoff(attempt int) time.Duration {
+	d := rp.Backoff
+	for i := 1; i < attempt && d < rp.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > rp.MaxBackoff {
+		d = rp.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// budget limits the retries to a ratio of the requests.
+type budget struct {
+	ratio  float64
+	max    float64
+	tokens float64
+	lck    sync.Mutex
+}
+
+func newBudget(ratio float64, min int) *budget {
+	return &budget{
+		ratio:  ratio,
+		max:    float64(min),
+		tokens: float64(min),
+	}
+}
+
+func (b *budget) deposit() {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+func (b *budget) withdraw() bool {
+	b.lck.Lock()
+	defer b.lck.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// attempts are the servers already tried by the request.
+type attempts struct {
+	tried map[string]bool
+	lck   sync.Mutex
+}
+
+func (a *attempts) add(dst string) {
+	a.lck.Lock()
+	defer a.lck.Unlock()
+	a.tried[dst] = true
+}
+
+func (a *attempts) has(dst string) bool {
+	a.lck.Lock()
+	defer a.lck.Unlock()
+	return a.tried[dst]
+}
+
+// tried returns true if the request was already sent to dst.
+func tried(req *http.Request, dst string) bool {
+	a, ok := req.Context().Value(ctxAttempts).(*attempts)
+	if !ok {
+		return false
+	}
+	return a.has(dst)
+}
+
+// try records that the request was sent to dst.
+func try(req *http.Request, dst string) {
+	a, ok := req.Context().Value(ctxAttempts).(*attempts)
+	if !ok {
+		return
+	}
+	a.add(dst)
+}
+
 // Retry try multiple time to get a correct response from the handler.
 func Retry(times int, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	return RetryWith(&RetryPolicy{Attempts: times}, handler)
+}
+
+// RetryWith sends the request again to the handler while the response is a
+// server error, the method can be retried and the route retry budget isn't
+// exhausted. The request body is buffered to be sent again and the response is
+// reset between the attempts. Balance sends each attempt to a different
+// server, if there is one.
+func RetryWith(policy *RetryPolicy, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	rp := policy.withDefaults()
+	b := newBudget(rp.Budget, rp.BudgetMin)
 	return func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		b.deposit()
+		times := rp.Attempts
+		if !rp.retryable(req.Method) {
+			times = 1
+		}
+
+		var body []byte
+		if times > 1 && req.Body != nil && req.Body != http.NoBody {
+			buf, err := ioutil.ReadAll(io.LimitReader(req.Body, rp.MaxBodySize+1))
+			if err != nil {
+				log.Tag("router", "retry").Error("Can't read the body: ", err)
+			}
+			if err != nil || int64(len(buf)) > rp.MaxBodySize {
+				// Body too big to buffer, send it only once.
+				times = 1
+				req.Body = readCloser{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+			} else {
+				body = buf
+			}
+		}
+
+		ctx := context.WithValue(req.Context(), ctxAttempts, &attempts{tried: make(map[string]bool)})
+		header := rw.Header().Clone()
 		for i := 0; i < times; i++ {
-			handler(rw, req)
-			code := rw.ResponseCode()
-			if !(code >= 500 && code < 600) {
+			if i > 0 {
+				if !b.withdraw() {
+					log.Tag("router", "retry").DebugLevel().Printf("retry budget exhausted (%v, %v)", req.Method, req.URL.Path)
+					break
+				}
+				select {
+				case <-time.After(rp.backoff(i)):
+				case <-ctx.Done():
+					return
+				}
+				// Discard the failed attempt.
+				rw.Reset()
+				for k, v := range header {
+					rw.Header()[k] = append([]string(nil), v...)
+				}
+			}
+			// The handlers change the request, each attempt gets a copy.
+			r := req.Clone(ctx)
+			if body != nil {
+				r.Body = ioutil.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+			}
+			handler(rw, r)
+			if rw.Hijacked() {
+				break
+			}
+			if !serverError(rw.ResponseCode()) {
 				break
 			}
 		}
 	}
 }
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+func TestRetryFailover(t *testing.T) {
+	HTTPClient = http.DefaultClient
+	var bad int32
+	badServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&bad, 1)
+		w.Header().Set("X-Server", "bad")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer badServer.Close()
+	goodServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("X-Server", "good")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}))
+	defer goodServer.Close()
+
+	rr := NewRoundRobin()
+	rr.AddAddrs("PUT", "/", badServer.URL)
+	rr.AddAddrs("PUT", "/", goodServer.URL)
+	h := RetryWith(&RetryPolicy{Attempts: 3, Backoff: time.Millisecond}, Balance(rr, Proxy("", time.Second)))
+
+	w := responsewriter.NewResponseWriter()
+	w.Header().Set("X-Before", "oi")
+	r, err := http.NewRequest("PUT", "http://localhost/", bytes.NewBufferString("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(w, r)
+	if code := w.ResponseCode(); code != http.StatusOK {
+		t.Fatal("wrong response code", code)
+	}
+	if body := string(w.Bytes()); body != "payload" {
+		t.Fatal("wrong body", body)
+	}
+	if v := w.Header()["X-Server"]; len(v) != 1 || v[0] != "good" {
+		t.Fatal("wrong header", v)
+	}
+	if v := w.Header().Get("X-Before"); v != "oi" {
+		t.Fatal("header lost", v)
+	}
+	if n := atomic.LoadInt32(&bad); n != 1 {
+		t.Fatal("wrong number of attempts", n)
+	}
+}
+
+func TestRetryUntried(t *testing.T) {
+	// The consistent hash always returns the same server.
+	ch := NewConsistentHash(HashRemoteIP(), 10)
+	ch.AddAddrs("GET", "/", "http://10.0.0.1")
+	ch.AddAddrs("GET", "/", "http://10.0.0.2")
+	var dsts []string
+	h := Retry(2, Balance(ch, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		dsts = append(dsts, r.Context().Value(ctxName).(string))
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	r, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RemoteAddr = "1.2.3.4:1234"
+	h(responsewriter.NewResponseWriter(), r)
+	if len(dsts) != 2 || dsts[0] == dsts[1] {
+		t.Fatal("same server retried", dsts)
+	}
+}
+
+func TestRetryMethods(t *testing.T) {
+	count := 0
+	h := RetryWith(&RetryPolicy{Attempts: 3, Backoff: time.Millisecond}, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		count++
+		rw.WriteHeader(http.StatusInternalServerError)
+	})
+	r, err := http.NewRequest("POST", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(responsewriter.NewResponseWriter(), r)
+	if count != 1 {
+		t.Fatal("post retried", count)
+	}
+
+	count = 0
+	h = RetryWith(&RetryPolicy{Attempts: 3, Backoff: time.Millisecond, Methods: []string{"POST"}}, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		count++
+		rw.WriteHeader(http.StatusInternalServerError)
+	})
+	h(responsewriter.NewResponseWriter(), r)
+	if count != 3 {
+		t.Fatal("wrong number of attempts", count)
+	}
+}
+
+func TestRetryBodyLimit(t *testing.T) {
+	var bodies []string
+	h := RetryWith(&RetryPolicy{Attempts: 3, Backoff: time.Millisecond, MaxBodySize: 4}, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bodies = append(bodies, string(body))
+		rw.WriteHeader(http.StatusInternalServerError)
+	})
+	r, err := http.NewRequest("PUT", "http://localhost/", strings.NewReader("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(responsewriter.NewResponseWriter(), r)
+	if len(bodies) != 1 || bodies[0] != "0123456789" {
+		t.Fatal("wrong bodies", bodies)
+	}
+
+	bodies = nil
+	r, err = http.NewRequest("PUT", "http://localhost/", strings.NewReader("0123"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(responsewriter.NewResponseWriter(), r)
+	if len(bodies) != 3 {
+		t.Fatal("wrong number of attempts", len(bodies))
+	}
+	for _, body := range bodies {
+		if body != "0123" {
+			t.Fatal("wrong body", body)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	count := 0
+	h := RetryWith(&RetryPolicy{Attempts: 3, Backoff: time.Millisecond, Budget: 0.01, BudgetMin: 1}, func(rw *responsewriter.ResponseWriter, r *http.Request) {
+		count++
+		rw.WriteHeader(http.StatusInternalServerError)
+	})
+	r, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h(responsewriter.NewResponseWriter(), r)
+	if count != 2 {
+		t.Fatal("wrong number of attempts", count)
+	}
+	count = 0
+	h(responsewriter.NewResponseWriter(), r)
+	if count != 1 {
+		t.Fatal("budget not respected", count)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	rp := (&RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}).withDefaults()
+	for i := 0; i < 100; i++ {
+		if d := rp.backoff(1); d < 0 || d > 10*time.Millisecond {
+			t.Fatal("wrong backoff", d)
+		}
+		if d := rp.backoff(10); d < 0 || d > 50*time.Millisecond {
+			t.Fatal("wrong backoff", d)
+		}
+	}
+}
@@ -9,16 +9,15 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/fcavani/e"
-	fhttp "github.com/fcavani/http"
 	log "github.com/fcavani/slog"
 	"github.com/fcavani/text"
-	"github.com/sony/gobreaker"
 	"gopkg.in/fcavani/httprouter.v2"
 
 	"github.com/fcavani/droute/errhandler"
@@ -75,12 +74,49 @@ type Router struct {
 	routers     Routers
 	handler     http.Handler
 	middlewares func(last responsewriter.HandlerFunc) responsewriter.HandlerFunc
-	cbs         map[string]*gobreaker.CircuitBreaker
+	cbs         *Cbs
+	breaker     *BreakerSettings
+
+	table    map[routeKey]*routeEntry
+	tableLck sync.RWMutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	hc     *HealthCheck
+	health map[string]*healthState
+
+	affinity *AffinityCookie
+
+	trust    map[string]bool
+	trustLck sync.RWMutex
+
+	retry *RetryPolicy
+
+	// balancers are the names of the LoadBalance of each router.
+	balancers map[string]string
+
+	// admin serves the control API in a separate address.
+	admin   *httprouter.Router
+	auth    *ControlAuth
+	authLck sync.RWMutex
+
+	// watch sends the changes in the routes to the watches.
+	watch *watchHub
+
+	// store keeps the routes between restarts, see SetStore.
+	store             RouteStore
+	restoreUnverified bool
+	// unverified are the restored servers that didn't pass a health check
+	// yet.
+	unverified map[string]struct{}
 }
 
-// HTTPHandlers plugs toggeder the handlers.
+// HTTPHandlers plugs toggeder the handlers. The control API v1 is behind them,
+// but its watches, they are long lived and would hold the handlers, like a
+// rate limit, until they end.
 func (r *Router) HTTPHandlers(f func(first http.Handler) http.Handler) {
-	r.handler = f(r.hostSwitch)
+	r.handler = r.watchSwitch(f(r.apiSwitch(false, r.hostSwitch)))
 }
 
 // Middlewares sets the chain of middlers used by the router handlers.
@@ -94,7 +130,8 @@ func (r *Router) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	r.handler.ServeHTTP(rw, req)
 }
 
-//Start listners
+// Start listners. Each route has its own LoadBalance, a clone of lb if it is a
+// Cloner, see SetBalancer and Route.Balancer for other strategies.
 func (r *Router) Start(routers Routers, lb LoadBalance, to time.Duration, proxyRetries int) error {
 	r.proxyTimeout = to
 	r.proxyRetries = proxyRetries
@@ -105,14 +142,22 @@ func (r *Router) Start(routers Routers, lb LoadBalance, to time.Duration, proxyR
 	if defRouter == nil {
 		return e.Forward("no default router")
 	}
-	r.handler = r.hostSwitch
+	r.handler = r.apiSwitch(false, r.hostSwitch)
 	r.middlewares = func(last responsewriter.HandlerFunc) responsewriter.HandlerFunc {
 		return last
 	}
 
 	r.hostSwitch.Set("localhost", defRouter)
 
-	r.cbs = make(map[string]*gobreaker.CircuitBreaker)
+	r.cbs = r.newCbs(nil)
+	r.table = make(map[routeKey]*routeEntry)
+	r.trust = make(map[string]bool)
+	r.balancers = make(map[string]string)
+	r.watch = newWatchHub()
+	r.unverified = make(map[string]struct{})
+
+	r.stop = make(chan struct{})
+	go r.reaper(r.stop)
 
 	// Add internal routes to the endpoints for adding new routes by the remote
 	// client.
@@ -143,12 +188,79 @@ func (r *Router) SetHostSwitch(domain, routername string) error {
 
 // Stop halts the router and close the listners.
 func (r *Router) Stop() error {
+	r.stopOnce.Do(func() {
+		if r.stop != nil {
+			close(r.stop)
+		}
+	})
+	return nil
+}
+
+// TrustForwardHeaders sets if the forwarding headers (X-Forwarded-For,
+// X-Forwarded-Proto, X-Forwarded-Host and Forwarded) sent by the clients of
+// the router routerName are trusted. If not, the default, they are replaced by
+// the ones made by the proxy. Set it to true only if the router is behind
+// another proxy.
+func (r *Router) TrustForwardHeaders(routerName string, trust bool) error {
+	if _, found := r.routers[routerName]; !found {
+		return e.New("router not found")
+	}
+	r.trustLck.Lock()
+	defer r.trustLck.Unlock()
+	r.trust[routerName] = trust
 	return nil
 }
 
+func (r *Router) trusted(routerName string) func() bool {
+	return func() bool {
+		r.trustLck.RLock()
+		defer r.trustLck.RUnlock()
+		return r.trust[routerName]
+	}
+}
+
+// SetRetryPolicy sets the retry policy of the routes added after the call. If
+// p.Attempts is zero the proxyRetries passed to Start is used. The routes can
+// have their own policy, see Route.Retry.
+func (r *Router) SetRetryPolicy(p *RetryPolicy) {
+	r.retry = p
+}
+
+// retryPolicy returns the policy of the route or the router one if route is
+// nil.
+func (r *Router) retryPolicy(route *RetryPolicy) *RetryPolicy {
+	p := RetryPolicy{}
+	if route != nil {
+		p = *route
+	} else if r.retry != nil {
+		p = *r.retry
+	}
+	if p.Attempts == 0 {
+		p.Attempts = r.proxyRetries
+	}
+	return &p
+}
+
 // Add a new handler to domain. If routerName doesn't exist add route
 // to the default router.
-func (r *Router) Add(routerName, method, path, dst string) (err error) {
+func (r *Router) Add(routerName, method, path, dst string) error {
+	return r.Register(&Route{
+		Methode: method,
+		Router:  routerName,
+		Path:    path,
+		RedirTo: dst,
+	})
+}
+
+// Register adds the route to the router. If route.TTL isn't zero the server
+// is removed from the route when the lease expires, see Renew.
+func (r *Router) Register(route *Route) error {
+	return r.register(route, true)
+}
+
+// register adds the route to the router and to the store if save is true.
+func (r *Router) register(route *Route, save bool) (err error) {
+	routerName, method, path, dst := route.Router, route.Methode, route.Path, route.RedirTo
 	defer func() {
 		r := recover()
 		if r == nil {
@@ -169,28 +281,12 @@ func (r *Router) Add(routerName, method, path, dst string) (err error) {
 		}
 		log.Errorf("Can't add route (%v, %v, %v) error: %v", routerName, method, path, err)
 	}()
-	err = text.CheckLettersNumber(routerName, 2, 128)
-	if err != nil && routerName != DefaultRouter {
-		err = e.Push(err, "invalid route name")
-		return
-	}
-	err = text.CheckLettersNumber(method, 3, 20)
+	path, err = checkRoute(routerName, method, path, dst)
 	if err != nil {
-		err = e.Push(err, "invalid method name")
 		return
 	}
-	if path != "" {
-		err = text.CheckFileName(path, 1, 128)
-		if err != nil {
-			err = e.Push(err, "invalid path name")
-			return
-		}
-	} else {
-		path = "/"
-	}
-	_, err = url.Parse(dst)
+	err = checkPolicy(route)
 	if err != nil {
-		err = e.Push(err, "invalid destiny host name")
 		return
 	}
 	router := r.routers.Get(routerName)
@@ -199,54 +295,341 @@ func (r *Router) Add(routerName, method, path, dst string) (err error) {
 		return
 	}
 
-	if h, _, redir := router.Lookup(method, path); h != nil || redir {
-		// if the method/path exist return, or only add the new address for the
-		// new server.
+	key := routeKey{router: routerName, method: method, path: path}
+
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+
+	entry, found := r.table[key]
+	if found && entry.active {
+		// The handler is already in the router, add the new server.
 		log.DebugLevel().Printf("Route exists updating proxy. (%v, %v, %v => %v)", routerName, method, path, dst)
-		r.lb.AddAddrs(method, path, dst)
+		err = r.checkOptions(entry.opts, route)
+		if err != nil {
+			return
+		}
+		old := entry.get(dst)
+		var weight int
+		var tag string
+		if old != nil {
+			weight, tag = old.weight, old.tag
+		}
+		b := entry.add(key, dst, route.Tag, route.TTL, route.Weight)
+		if r.available(entry, dst) {
+			r.lbAdd(entry, key, b)
+		}
+		switch {
+		case old == nil || tag != b.tag:
+			r.publish(EventAdd, key, b, r.healthOf(dst))
+		case weight != b.weight:
+			r.publish(EventWeight, key, b, "")
+		}
+		if save {
+			r.save(key, entry)
+		}
 		return
 	}
 
-	router.Handle(method, path,
-		responsewriter.Handler(
-			r.middlewares(
-				Retry(r.proxyRetries,
-					Balance(r.lb, //route.Remove(method, path)
-						CircuitBrake(r.cbs,
-							Proxy("", r.proxyTimeout),
+	if !found {
+		if h, _, redir := router.Lookup(method, path); h != nil || redir {
+			// The method/path is handled by the router itself, not by a
+			// proxy route.
+			err = e.New("route exists and isn't a proxy")
+			return
+		}
+	}
+
+	// A disabled route is created again with the new options, its handler
+	// is already in the router.
+	entry, err = r.newEntry(key, route)
+	if err != nil {
+		return
+	}
+	if !found {
+		router.Handle(method, path, r.enabled(key))
+	}
+	b := entry.add(key, dst, route.Tag, route.TTL, route.Weight)
+	r.table[key] = entry
+	if r.available(entry, dst) {
+		r.lbAdd(entry, key, b)
+	}
+	r.publish(EventAdd, key, b, r.healthOf(dst))
+	if save {
+		r.save(key, entry)
+	}
+	log.DebugLevel().Printf("Route add to proxy. (%v, %v, %v => %v)", routerName, method, path, dst)
+	return
+}
+
+// newEntry creates the entry of the route key with the proxy options of route
+// and its handler.
+func (r *Router) newEntry(key routeKey, route *Route) (*routeEntry, error) {
+	rules, err := compileRules(route.Rules)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+
+	cbs := r.cbs
+	if r.breaker != nil && r.breaker.PerRoute {
+		cbs = r.newCbs(r.breaker)
+	}
+	lb := r.balancer(route)
+	var shadow LoadBalance
+	var m *mirror
+	if route.Mirror != nil {
+		shadow = r.balancer(route)
+		m = newMirror(route.Mirror,
+			Balance(shadow,
+				Rewrite(route.StripPrefix, route.Rewrite,
+					Proxy("", r.timeout(route)),
+				),
+			),
+		)
+	}
+
+	handler := responsewriter.Handler(
+		r.middlewares(
+			Forwarded(r.trusted(key.router),
+				m.handler(
+					RetryWith(r.retryPolicy(route.Retry),
+						hedge(route.Hedge,
+							r.tagBalance(key, lb,
+								r.inflight(key,
+									CircuitBrake(cbs,
+										Rewrite(route.StripPrefix, route.Rewrite,
+											Proxy("", r.timeout(route)),
+										),
+									),
+								),
+							),
 						),
 					),
 				),
 			),
 		),
 	)
-	r.lb.AddAddrs(method, path, dst)
-	log.DebugLevel().Printf("Route add to proxy. (%v, %v, %v => %v)", routerName, method, path, dst)
+	opts := *route
+	opts.Backends = nil
+	opts.MirrorStats = nil
+	if route.Split != nil {
+		sp := *route.Split
+		opts.Split = &sp
+	}
+	opts.Rules = rulesOf(rules)
+	return &routeEntry{
+		active:  true,
+		handler: Pattern(key.path, handler),
+		cbs:     cbs,
+		lb:      lb,
+		opts:    &opts,
+		mirror:  m,
+		shadow:  shadow,
+		tags:    make(map[string]LoadBalance),
+		rules:   rules,
+		split:   opts.Split,
+	}, nil
+}
+
+// Del removes the server dst from the route. When the last server of the route
+// is removed the route stops to answer the requests.
+func (r *Router) Del(routerName, method, path, dst string) (err error) {
+	defer func() {
+		if err != nil {
+			log.Errorf("Can't remove route (%v, %v, %v => %v) error: %v", routerName, method, path, dst, err)
+			return
+		}
+		log.DebugLevel().Printf("Route (%v, %v, %v => %v) removed.", routerName, method, path, dst)
+	}()
+	path, err = checkRoute(routerName, method, path, dst)
+	if err != nil {
+		return
+	}
+	router := r.routers.Get(routerName)
+	if router == nil {
+		err = e.New("router not found")
+		return
+	}
+
+	key := routeKey{router: routerName, method: method, path: path}
+
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+
+	entry, found := r.table[key]
+	if !found || !entry.active {
+		err = e.New("route not found")
+		return
+	}
+	b := entry.get(dst)
+	if b == nil {
+		err = e.New("destiny not found")
+		return
+	}
+	entry.del(dst)
+	entry.remove(key, b)
+	r.publish(EventRemove, key, b, "")
+	if len(entry.dsts) == 0 {
+		entry.active = false
+		log.DebugLevel().Printf("Route (%v, %v, %v) disabled, no more servers.", routerName, method, path)
+	}
+	r.save(key, entry)
+	return
+}
+
+// DelRoute removes all servers of the route, it stops to answer the requests.
+func (r *Router) DelRoute(routerName, method, path string) (err error) {
+	defer func() {
+		if err != nil {
+			log.Errorf("Can't remove route (%v, %v, %v) error: %v", routerName, method, path, err)
+			return
+		}
+		log.DebugLevel().Printf("Route (%v, %v, %v) removed.", routerName, method, path)
+	}()
+	path, err = checkRoute(routerName, method, path, "")
+	if err != nil {
+		return
+	}
+
+	key := routeKey{router: routerName, method: method, path: path}
+
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+
+	entry, found := r.table[key]
+	if !found || !entry.active {
+		err = e.New("route not found")
+		return
+	}
+	for _, b := range entry.dsts {
+		entry.remove(key, b)
+		r.publish(EventRemove, key, b, "")
+	}
+	entry.dsts = nil
+	entry.active = false
+	r.save(key, entry)
 	return
 }
 
-// func (r *Router) Del(routerName string) (err error) {
-// 	router := r.routers.Get(routerName)
-// 	if router == nil {
-// 		err = e.New("router not found")
-// 		return
-// 	}
-// 	router.
-// }
+// lbAdd adds the server to the LoadBalance of its tag with its weight, and to
+// the LoadBalance of the route if it is untagged. tableLck must be locked.
+func (r *Router) lbAdd(entry *routeEntry, key routeKey, b *backend) {
+	lbs := []LoadBalance{entry.shadow}
+	if !entry.shadowed(b.tag) {
+		tlb, found := entry.tags[b.tag]
+		if !found {
+			tlb = r.balancer(entry.opts)
+			entry.tags[b.tag] = tlb
+		}
+		lbs = []LoadBalance{tlb}
+		if b.tag == "" {
+			lbs = append(lbs, entry.lb)
+		}
+	}
+	for _, lb := range lbs {
+		lb.AddAddrs(key.method, key.path, b.addr)
+		if w, ok := lb.(Weighter); ok {
+			w.SetWeight(key.method, key.path, b.addr, b.weight)
+		}
+	}
+}
+
+// enabled calls the handler of the route key if it is active. The pattern of
+// the route goes in the context of the request.
+func (r *Router) enabled(key routeKey) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		r.tableLck.RLock()
+		entry, found := r.table[key]
+		r.tableLck.RUnlock()
+		if !found || !entry.active {
+			errhandler.ErrHandler(w, http.StatusNotFound, e.New("route not found"))
+			return
+		}
+		entry.handler(w, req)
+	}
+}
+
+func (r *Router) active(key routeKey) bool {
+	r.tableLck.RLock()
+	defer r.tableLck.RUnlock()
+	entry, found := r.table[key]
+	return found && entry.active
+}
+
+// checkRoute validates the parameters of a route and returns the path
+// normalized.
+func checkRoute(routerName, method, path, dst string) (string, error) {
+	err := text.CheckLettersNumber(routerName, 2, 128)
+	if err != nil && routerName != DefaultRouter {
+		return "", e.Push(err, "invalid route name")
+	}
+	err = text.CheckLettersNumber(method, 3, 20)
+	if err != nil {
+		return "", e.Push(err, "invalid method name")
+	}
+	if path != "" {
+		err = text.CheckFileName(path, 1, 128)
+		if err != nil {
+			return "", e.Push(err, "invalid path name")
+		}
+	} else {
+		path = "/"
+	}
+	_, err = url.Parse(dst)
+	if err != nil {
+		return "", e.Push(err, "invalid destiny host name")
+	}
+	return path, nil
+}
 
+// Get returns the routes of the router, the routes of the control API are left
+// out.
 func (r *Router) Get(routerName string) (rs Routes, err error) {
-	router := r.routers.Get(routerName)
-	if router == nil {
+	router, found := r.routers[routerName]
+	if !found {
 		return nil, e.New("router not found")
 	}
 	routes := make(Routes, 0)
+	r.tableLck.RLock()
+	defer r.tableLck.RUnlock()
 	router.HandlerPaths(true, func(method string, path string, h http.HandlerFunc) bool {
-		routes = append(routes, &Route{
+		entry, found := r.table[routeKey{router: routerName, method: method, path: path}]
+		if found && !entry.active {
+			return true
+		}
+		if !found && strings.HasPrefix(path, "/_router/") {
+			return true
+		}
+		route := &Route{
 			Methode: method,
 			Router:  routerName,
 			Path:    path,
 			RedirTo: "", // TODO: RetidTo
-		})
+		}
+		if found {
+			route.Timeout = entry.opts.Timeout
+			route.Retry = entry.opts.Retry
+			route.Hedge = entry.opts.Hedge
+			route.Balancer = entry.opts.Balancer
+			route.StripPrefix = entry.opts.StripPrefix
+			route.Rewrite = entry.opts.Rewrite
+			route.Mirror = entry.opts.Mirror
+			route.Split = entry.opts.Split
+			route.Rules = entry.opts.Rules
+			if entry.mirror != nil {
+				route.MirrorStats = entry.mirror.stats()
+			}
+			route.Backends = make([]*Backend, 0, len(entry.dsts))
+			for _, b := range entry.dsts {
+				route.Backends = append(route.Backends, &Backend{
+					Addr:     b.addr,
+					Health:   r.healthOf(b.addr),
+					Weight:   b.weight,
+					Draining: !b.draining.IsZero(),
+					Tag:      b.tag,
+				})
+			}
+		}
+		routes = append(routes, route)
 		return true
 	})
 	return routes, nil
@@ -278,29 +661,61 @@ func (r *Router) AddRoute(routerName, method, path string, handler http.HandlerF
 	router.Handle(method, path, handler)
 }
 
-// func (r *Router) DelRoute() {
-//
-// }
-
 func (r *Router) routes() {
-	def := r.routers[DefaultRouter]
+	r.admin = httprouter.New()
+	r.controlRoutes(r.routers[DefaultRouter], false)
+	r.controlRoutes(r.admin, true)
+}
+
+// controlRoutes adds the control API to router. admin is true for the router
+// of ControlHandler.
+func (r *Router) controlRoutes(router *httprouter.Router, admin bool) {
 	// Add a route.
-	def.POST("/_router/add",
-		localhost(
+	router.POST("/_router/add",
+		r.control(admin, errhandler.ErrHandler,
 			addRoute(r),
 		),
 	)
 
-	// Del a route where path is the url scaped path to the route.
-	// def.DELETE("/_router/del/:hostname/:path",
-	// 	localhost(
-	// 		delRoute(r),
-	// 	),
-	// )
+	// Renew the lease of a server.
+	router.POST("/_router/renew",
+		r.control(admin, errhandler.ErrHandler,
+			renewRoute(r),
+		),
+	)
 
-	// Get return all routes.
-	def.GET("/_router/get",
-		localhost(
+	// Del a server from a route.
+	router.DELETE("/_router/del",
+		r.control(admin, errhandler.ErrHandler,
+			delRoute(r),
+		),
+	)
+
+	// Drain a server of a route.
+	router.POST("/_router/drain",
+		r.control(admin, errhandler.ErrHandler,
+			drainRoute(r),
+		),
+	)
+
+	// Change the split policy of a route.
+	router.POST("/_router/split",
+		r.control(admin, errhandler.ErrHandler,
+			splitRoute(r),
+		),
+	)
+
+	// Replace the rules of a route.
+	router.POST("/_router/rules",
+		r.control(admin, errhandler.ErrHandler,
+			rulesRoute(r),
+		),
+	)
+
+	// Get return all routes. The router name is in the body or in the query
+	// parameter router.
+	router.GET("/_router/get",
+		r.control(admin, errhandler.ErrHandler,
 			getRoute(r),
 		),
 	)
@@ -316,6 +731,14 @@ const (
 	RouteOpDel Op = "delete"
 	//RouteOpGet is a op of type get.
 	RouteOpGet Op = "get"
+	//RouteOpRenew is a op of type renew.
+	RouteOpRenew Op = "renew"
+	//RouteOpDrain is a op of type drain.
+	RouteOpDrain Op = "drain"
+	//RouteOpSplit is a op of type split.
+	RouteOpSplit Op = "split"
+	//RouteOpRules is a op of type rules.
+	RouteOpRules Op = "rules"
 )
 
 // Routes describe a group of routes.
@@ -343,13 +766,66 @@ type Route struct {
 	Router  string
 	Path    string
 	RedirTo string
+	// TTL is the lease of the server RedirTo. Zero means no lease.
+	TTL time.Duration
+	// Weight of the server RedirTo in the route. Zero is the same as one.
+	Weight int
+	// Tag of the server RedirTo, like its version. The tagged servers only
+	// receive the requests sent to their tag by the rules or by the split
+	// policy. The servers with the tag of the mirror policy of the route are
+	// shadow servers.
+	Tag string `json:",omitempty"`
+	// The proxy options of the route are used when the route is created or
+	// when it was disabled, the other registers of the route must have the
+	// same options or leave them empty.
+	// Timeout of the requests to the servers. Zero uses the router timeout.
+	Timeout time.Duration `json:",omitempty"`
+	// Retry is the retry policy of the route. Nil uses the router policy.
+	Retry *RetryPolicy `json:",omitempty"`
+	// Hedge enables the hedged requests for the route.
+	Hedge *HedgePolicy `json:",omitempty"`
+	// Balancer is the name of the LoadBalance of the route, see Balancers.
+	// Empty uses the router LoadBalance.
+	Balancer string `json:",omitempty"`
+	// StripPrefix is removed from the path before sending the request to the
+	// server.
+	StripPrefix string `json:",omitempty"`
+	// Rewrite is the prefix added to the path, after StripPrefix is removed.
+	Rewrite string `json:",omitempty"`
+	// Mirror sends copies of the requests to the shadow servers of the route.
+	Mirror *MirrorPolicy `json:",omitempty"`
+	// Split splits the requests between the tags of the servers. It can be
+	// changed with SetSplit.
+	Split *SplitPolicy `json:",omitempty"`
+	// Rules send the requests to the servers of a tag. They are evaluated
+	// before the split and can be changed with SetRules.
+	Rules []*Rule `json:",omitempty"`
+	// MirrorStats are the results of the mirrored requests. Only filled by
+	// Get.
+	MirrorStats *MirrorStats `json:",omitempty"`
+	// Backends are the servers of the route. Only filled by Get.
+	Backends []*Backend `json:",omitempty"`
+}
+
+// Backend describe one server of a route.
+type Backend struct {
+	Addr   string
+	Health Health
+	Weight int
+	// Draining is true if the server doesn't receive new requests and will be
+	// removed.
+	Draining bool `json:",omitempty"`
+	// Tag of the server.
+	Tag string `json:",omitempty"`
+	// TTL is the lease of the server. Only used to add the server with the
+	// control API.
+	TTL time.Duration `json:",omitempty"`
 }
 
+// Search returns true if the pattern of one route matches path.
 func (rs Routes) Search(path string) bool {
 	for _, r := range rs {
-		if r.Path == path {
-			return true
-		} else if matchWildcard(r.Path, path) || matchNamedParam(r.Path, path) {
+		if matchPattern(r.Path, path) {
 			return true
 		}
 	}
@@ -377,7 +853,20 @@ func addRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
 			respError(w, http.StatusInternalServerError, err.Error(), RouteOpAdd)
 			return
 		}
-		err = r.Add(route.Router, route.Methode, route.Path, route.RedirTo)
+		err = authorize(req, route.Router, route.Path)
+		if err != nil {
+			response(
+				w,
+				http.StatusForbidden,
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpAdd,
+			)
+			return
+		}
+		err = r.Register(&route)
 		if err != nil {
 			response(
 				w,
@@ -402,33 +891,323 @@ func addRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-// func delRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
-// 	return func(w http.ResponseWriter, req *http.Request) {
-//    params := httprouter.Parameters(r)
-// 		hn := params.ByName("hostname")
-// 		p := params.ByName("path")
-//
-// 	}
-// }
+func renewRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var route Route
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, BodyLimitSize))
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpRenew)
+			return
+		}
+		err = req.Body.Close()
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpRenew)
+			return
+		}
+		err = json.Unmarshal(body, &route)
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpRenew)
+			return
+		}
+		err = authorize(req, route.Router, route.Path)
+		if err != nil {
+			response(
+				w,
+				http.StatusForbidden,
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpRenew,
+			)
+			return
+		}
+		err = r.Renew(route.Router, route.Methode, route.Path, route.RedirTo, route.TTL)
+		if err != nil {
+			response(
+				w,
+				422, // unprocessable entity
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpRenew,
+			)
+			return
+		}
+		response(
+			w,
+			http.StatusOK,
+			route.Methode,
+			route.Router,
+			route.Path,
+			"",
+			RouteOpRenew,
+		)
+	}
+}
 
-func getRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
+func delRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		var route Route
 		body, err := ioutil.ReadAll(io.LimitReader(req.Body, BodyLimitSize))
 		if err != nil {
-			respError(w, http.StatusInternalServerError, err.Error(), RouteOpGet)
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpDel)
 			return
 		}
 		err = req.Body.Close()
 		if err != nil {
-			respError(w, http.StatusInternalServerError, err.Error(), RouteOpGet)
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpDel)
 			return
 		}
 		err = json.Unmarshal(body, &route)
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpDel)
+			return
+		}
+		err = authorize(req, route.Router, route.Path)
+		if err != nil {
+			response(
+				w,
+				http.StatusForbidden,
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpDel,
+			)
+			return
+		}
+		err = r.Del(route.Router, route.Methode, route.Path, route.RedirTo)
+		if err != nil {
+			response(
+				w,
+				422, // unprocessable entity
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpDel,
+			)
+			return
+		}
+		response(
+			w,
+			http.StatusOK,
+			route.Methode,
+			route.Router,
+			route.Path,
+			"",
+			RouteOpDel,
+		)
+	}
+}
+
+func drainRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var route Route
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, BodyLimitSize))
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpDrain)
+			return
+		}
+		err = req.Body.Close()
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpDrain)
+			return
+		}
+		err = json.Unmarshal(body, &route)
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpDrain)
+			return
+		}
+		err = authorize(req, route.Router, route.Path)
+		if err != nil {
+			response(
+				w,
+				http.StatusForbidden,
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpDrain,
+			)
+			return
+		}
+		err = r.Drain(route.Router, route.Methode, route.Path, route.RedirTo)
+		if err != nil {
+			response(
+				w,
+				422, // unprocessable entity
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpDrain,
+			)
+			return
+		}
+		response(
+			w,
+			http.StatusOK,
+			route.Methode,
+			route.Router,
+			route.Path,
+			"",
+			RouteOpDrain,
+		)
+	}
+}
+
+func splitRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var route Route
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, BodyLimitSize))
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpSplit)
+			return
+		}
+		err = req.Body.Close()
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpSplit)
+			return
+		}
+		err = json.Unmarshal(body, &route)
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpSplit)
+			return
+		}
+		err = authorize(req, route.Router, route.Path)
+		if err != nil {
+			response(
+				w,
+				http.StatusForbidden,
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpSplit,
+			)
+			return
+		}
+		err = r.SetSplit(route.Router, route.Methode, route.Path, route.Split)
+		if err != nil {
+			response(
+				w,
+				422, // unprocessable entity
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpSplit,
+			)
+			return
+		}
+		response(
+			w,
+			http.StatusOK,
+			route.Methode,
+			route.Router,
+			route.Path,
+			"",
+			RouteOpSplit,
+		)
+	}
+}
+
+func rulesRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var route Route
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, BodyLimitSize))
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpRules)
+			return
+		}
+		err = req.Body.Close()
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpRules)
+			return
+		}
+		err = json.Unmarshal(body, &route)
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpRules)
+			return
+		}
+		err = authorize(req, route.Router, route.Path)
+		if err != nil {
+			response(
+				w,
+				http.StatusForbidden,
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpRules,
+			)
+			return
+		}
+		err = r.SetRules(route.Router, route.Methode, route.Path, route.Rules)
+		if err != nil {
+			response(
+				w,
+				422, // unprocessable entity
+				route.Methode,
+				route.Router,
+				route.Path,
+				err.Error(),
+				RouteOpRules,
+			)
+			return
+		}
+		response(
+			w,
+			http.StatusOK,
+			route.Methode,
+			route.Router,
+			route.Path,
+			"",
+			RouteOpRules,
+		)
+	}
+}
+
+func getRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var route Route
+		body, err := ioutil.ReadAll(io.LimitReader(req.Body, BodyLimitSize))
 		if err != nil {
 			respError(w, http.StatusInternalServerError, err.Error(), RouteOpGet)
 			return
 		}
+		err = req.Body.Close()
+		if err != nil {
+			respError(w, http.StatusInternalServerError, err.Error(), RouteOpGet)
+			return
+		}
+		if len(body) == 0 {
+			route.Router = req.URL.Query().Get("router")
+		} else {
+			err = json.Unmarshal(body, &route)
+			if err != nil {
+				respError(w, http.StatusInternalServerError, err.Error(), RouteOpGet)
+				return
+			}
+		}
+		id := identity(req)
+		if !id.allowedRouter(route.Router) {
+			responseRoutes(
+				w,
+				http.StatusForbidden,
+				route.Router,
+				"forbidden",
+				RouteOpGet,
+				nil,
+			)
+			return
+		}
+		if route.Router == "" {
+			route.Router = DefaultRouter
+		}
 		rs, err := r.Get(route.Router)
 		if err != nil {
 			responseRoutes(
@@ -441,15 +1220,24 @@ func getRoute(r *Router) func(w http.ResponseWriter, req *http.Request) {
 			)
 			return
 		}
+		if id != nil {
+			// Only the routes the client can change.
+			allowed := make(Routes, 0, len(rs))
+			for _, rt := range rs {
+				if id.allowedPath(rt.Path) {
+					allowed = append(allowed, rt)
+				}
+			}
+			rs = allowed
+		}
 		responseRoutes(
 			w,
-			http.StatusFound,
+			http.StatusOK,
 			route.Router,
-			err.Error(),
+			"",
 			RouteOpGet,
 			rs,
 		)
-		return
 	}
 }
 
@@ -555,19 +1343,3 @@ func responseRoutes(w http.ResponseWriter, code int, routerName, err string, op
 		log.Tag("router", "server", "rest").Error(er)
 	}
 }
-
-func localhost(f http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		ipstr, err := fhttp.RemoteIP(r)
-		if err != nil {
-			errhandler.ErrHandler(w, http.StatusForbidden, err)
-			return
-		}
-		ip := net.ParseIP(ipstr)
-		if ip.IsLoopback() {
-			f(w, r)
-			return
-		}
-		errhandler.ErrHandler(w, http.StatusForbidden, e.New("ip isn't loopback"))
-	}
-}
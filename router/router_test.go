@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -406,3 +407,315 @@ func TestRouterAddHandler(t *testing.T) {
 		t.Fatal("wrong error", resp.Err)
 	}
 }
+
+func TestRouterDel(t *testing.T) {
+	r := &Router{}
+
+	err := r.Start(NewRouters(), NewRoundRobin(), 60*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	HTTPClient = &http.Client{
+		Transport: &transport{},
+	}
+	defer func() {
+		HTTPClient = http.DefaultClient
+	}()
+
+	err = r.Add(DefaultRouter, "GET", "/del", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Add(DefaultRouter, "GET", "/del", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Del("a", "GET", "/del", "10.0.0.1")
+	if err != nil && !e.Contains(err, "invalid route name") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+	err = r.Del(DefaultRouter, "GET", "/notfound", "10.0.0.1")
+	if err != nil && !e.Contains(err, "route not found") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+	err = r.Del(DefaultRouter, "GET", "/del", "10.0.0.3")
+	if err != nil && !e.Contains(err, "destiny not found") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+
+	err = r.Del(DefaultRouter, "GET", "/del", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		w := responsewriter.NewResponseWriter()
+		req, err := http.NewRequest("GET", "http://localhost/en/del", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.ServeHTTP(w, req)
+		if code := w.ResponseCode(); code != 200 {
+			t.Fatal("wrong response code", code)
+		}
+		if dst := w.Header().Get("X-Dst-Serv"); dst != "10.0.0.2" {
+			t.Fatal("wrong destiny", dst)
+		}
+	}
+
+	err = r.Del(DefaultRouter, "GET", "/del", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := responsewriter.NewResponseWriter()
+	req, err := http.NewRequest("GET", "http://localhost/en/del", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.ServeHTTP(w, req)
+	if code := w.ResponseCode(); code != 404 {
+		t.Fatal("wrong response code", code)
+	}
+
+	rs, err := r.Get(DefaultRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.Search("/del") {
+		t.Fatal("route wasn't removed")
+	}
+
+	err = r.Del(DefaultRouter, "GET", "/del", "10.0.0.2")
+	if err != nil && !e.Contains(err, "route not found") {
+		t.Fatal(err)
+	} else if err == nil {
+		t.Fatal("nil error")
+	}
+
+	err = r.Add(DefaultRouter, "GET", "/del", "10.0.0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w = responsewriter.NewResponseWriter()
+	req, err = http.NewRequest("GET", "http://localhost/en/del", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.ServeHTTP(w, req)
+	if code := w.ResponseCode(); code != 200 {
+		t.Fatal("wrong response code", code)
+	}
+	if dst := w.Header().Get("X-Dst-Serv"); dst != "10.0.0.3" {
+		t.Fatal("wrong destiny", dst)
+	}
+}
+
+func TestRouterDelHandler(t *testing.T) {
+	r := &Router{}
+
+	err := r.Start(NewRouters(), NewRoundRobin(), 60*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Add(DefaultRouter, "GET", "/", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := json.Marshal(&Route{
+		Methode: "GET",
+		Router:  DefaultRouter,
+		Path:    "/",
+		RedirTo: "10.0.0.1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := http.NewRequest("DELETE", "http://localhost/en/_router/del", bytes.NewBuffer(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("X-Real-Ip", "10.0.0.1")
+	rw := responsewriter.NewResponseWriter()
+	r.ServeHTTP(rw, req)
+	if code := rw.ResponseCode(); code != 403 {
+		t.Fatal("wrong response code", code)
+	}
+
+	req, err = http.NewRequest("DELETE", "http://localhost/en/_router/del", bytes.NewBuffer(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("X-Real-Ip", "127.0.0.1")
+	rw = responsewriter.NewResponseWriter()
+	r.ServeHTTP(rw, req)
+	if code := rw.ResponseCode(); code != 200 {
+		t.Fatal("wrong response code", code)
+	}
+	var resp Response
+	err = json.NewDecoder(rw).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Err != "" {
+		t.Fatal(resp.Err)
+	}
+	if resp.Op != "delete" {
+		t.Fatal("wrong operation", resp.Op)
+	}
+
+	req, err = http.NewRequest("DELETE", "http://localhost/en/_router/del", bytes.NewBuffer(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("X-Real-Ip", "127.0.0.1")
+	rw = responsewriter.NewResponseWriter()
+	r.ServeHTTP(rw, req)
+	if code := rw.ResponseCode(); code != 422 {
+		t.Fatal("wrong response code", code)
+	}
+	err = json.NewDecoder(rw).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(resp.Err, "route not found") {
+		t.Fatal("wrong error", resp.Err)
+	}
+}
+
+func TestRouterBalancers(t *testing.T) {
+	routers := NewRouters()
+	routers.Set("other", httprouter.New())
+	r := &Router{}
+	err := r.Start(routers, NewRoundRobin(), 60*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	err = r.SetBalancer("blurf", "p2c")
+	if err == nil || !e.Contains(err, "router not found") {
+		t.Fatal("wrong error", err)
+	}
+	err = r.SetBalancer("other", "blurf")
+	if err == nil || !e.Contains(err, "invalid balancer") {
+		t.Fatal("wrong error", err)
+	}
+	err = r.SetBalancer("other", "p2c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Add(DefaultRouter, "GET", "/same", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Add("other", "GET", "/same", "10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same path in different routers doesn't share the servers.
+	def := routeLB(r, DefaultRouter, "GET", "/same")
+	other := routeLB(r, "other", "GET", "/same")
+	for i := 0; i < 4; i++ {
+		if dst := def.Next("GET", "/same"); dst != "10.0.0.1" {
+			t.Fatal("wrong server", dst)
+		}
+		if dst := other.Next("GET", "/same"); dst != "10.0.0.2" {
+			t.Fatal("wrong server", dst)
+		}
+		other.Done("GET", "/same", "10.0.0.2")
+	}
+	if _, ok := def.(*RoundRobin); !ok {
+		t.Fatalf("wrong load balance %T", def)
+	}
+	od, ok := other.(*OutlierDetection)
+	if !ok {
+		t.Fatalf("wrong load balance %T", other)
+	}
+	if _, ok := od.LoadBalance.(*P2C); !ok {
+		t.Fatalf("wrong load balance %T", od.LoadBalance)
+	}
+}
+
+func TestRouterRegisterAgain(t *testing.T) {
+	paths := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+	}))
+	defer server.Close()
+	HTTPClient = http.DefaultClient
+
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), 60*time.Second, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	get := func() string {
+		req, err := http.NewRequest("GET", "http://localhost/en/again", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK {
+			t.Fatal("wrong response code", rw.Code)
+		}
+		return <-paths
+	}
+
+	err = r.Register(&Route{Methode: "GET", Router: DefaultRouter, Path: "/again", RedirTo: server.URL, Rewrite: "/v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := get(); path != "/en/v1/again" {
+		t.Fatal("wrong path", path)
+	}
+	// The options of an active route can't change, but can be left empty.
+	err = r.Register(&Route{Methode: "GET", Router: DefaultRouter, Path: "/again", RedirTo: server.URL, Rewrite: "/v2"})
+	if err == nil || !e.Contains(err, "route exists with other rewrite") {
+		t.Fatal("wrong error", err)
+	}
+	err = r.Register(&Route{Methode: "GET", Router: DefaultRouter, Path: "/again", RedirTo: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := get(); path != "/en/v1/again" {
+		t.Fatal("wrong path", path)
+	}
+
+	// A disabled route is created again with the new options.
+	err = r.DelRoute(DefaultRouter, "GET", "/again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Register(&Route{Methode: "GET", Router: DefaultRouter, Path: "/again", RedirTo: server.URL, Rewrite: "/v2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path := get(); path != "/en/v2/again" {
+		t.Fatal("wrong path", path)
+	}
+}
+
+// routeLB returns the LoadBalance of the route.
+func routeLB(r *Router, routerName, method, path string) LoadBalance {
+	r.tableLck.RLock()
+	defer r.tableLck.RUnlock()
+	return r.table[routeKey{router: routerName, method: method, path: path}].lb
+}
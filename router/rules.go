@@ -0,0 +1,208 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/fcavani/e"
+	fhttp "github.com/fcavani/http"
+	log "github.com/fcavani/slog"
+)
+
+// Rule sends the requests of a route that match all its conditions to the
+// servers with the tag Tag. A rule without conditions matches all requests.
+// If the tag has no server the next rule is evaluated. The requests that
+// match no rule go to the split policy of the route or to its untagged
+// servers.
+type Rule struct {
+	// Priority of the rule. The rules with higher priority are evaluated first
+	// and the ones with the same priority in the order given.
+	Priority int `json:",omitempty"`
+	// Headers are the headers of the request with its values. An empty value
+	// matches any value. The headers with a list, like Accept, match if one
+	// of the elements, without the parameters, is the value.
+	Headers map[string]string `json:",omitempty"`
+	// Query are the query parameters of the request with its values. An empty
+	// value matches any value.
+	Query map[string]string `json:",omitempty"`
+	// Cookies are the cookies of the request with its values. An empty value
+	// matches any value.
+	Cookies map[string]string `json:",omitempty"`
+	// CIDRs are the networks of the client address, it must be in one of
+	// them.
+	CIDRs []string `json:",omitempty"`
+	// ContentType is the media type of the request body.
+	ContentType string `json:",omitempty"`
+	Tag         string
+}
+
+// rule is a Rule with the networks parsed.
+type rule struct {
+	*Rule
+	nets []*net.IPNet
+}
+
+// compileRules validates a copy of the rules and sorts them by priority.
+func compileRules(rules []*Rule) ([]*rule, error) {
+	compiled := make([]*rule, 0, len(rules))
+	for _, r := range rules {
+		if r == nil {
+			return nil, e.New("nil rule")
+		}
+		cp := *r
+		rl := &rule{Rule: &cp}
+		for _, cidr := range r.CIDRs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, e.Push(err, "invalid rule cidr")
+			}
+			rl.nets = append(rl.nets, n)
+		}
+		if r.ContentType != "" {
+			_, _, err := mime.ParseMediaType(r.ContentType)
+			if err != nil {
+				return nil, e.Push(err, "invalid rule content type")
+			}
+		}
+		compiled = append(compiled, rl)
+	}
+	sort.SliceStable(compiled, func(i, j int) bool {
+		return compiled[i].Priority > compiled[j].Priority
+	})
+	return compiled, nil
+}
+
+func (rl *rule) match(req *http.Request) bool {
+	for name, val := range rl.Headers {
+		if !headerMatch(req.Header[http.CanonicalHeaderKey(name)], val) {
+			return false
+		}
+	}
+	if len(rl.Query) > 0 {
+		query := req.URL.Query()
+		for name, val := range rl.Query {
+			if !valuesMatch(query[name], val) {
+				return false
+			}
+		}
+	}
+	for name, val := range rl.Cookies {
+		c, err := req.Cookie(name)
+		if err != nil || (val != "" && c.Value != val) {
+			return false
+		}
+	}
+	if len(rl.nets) > 0 && !rl.client(req) {
+		return false
+	}
+	if rl.ContentType != "" {
+		mt, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if err != nil || !strings.EqualFold(mt, rl.ContentType) {
+			return false
+		}
+	}
+	return true
+}
+
+// client returns true if the client address is in one of the networks.
+func (rl *rule) client(req *http.Request) bool {
+	addr, err := fhttp.RemoteIP(req)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range rl.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func valuesMatch(vals []string, want string) bool {
+	if len(vals) == 0 {
+		return false
+	}
+	if want == "" {
+		return true
+	}
+	for _, v := range vals {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func headerMatch(vals []string, want string) bool {
+	if valuesMatch(vals, want) {
+		return true
+	}
+	for _, v := range vals {
+		for _, elem := range strings.Split(v, ",") {
+			if i := strings.Index(elem, ";"); i >= 0 {
+				elem = elem[:i]
+			}
+			if strings.TrimSpace(elem) == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetRules replaces the rules of the route. Nil removes the rules.
+func (r *Router) SetRules(routerName, method, path string, rules []*Rule) (err error) {
+	defer func() {
+		if err != nil {
+			log.Errorf("Can't set the rules (%v, %v, %v) error: %v", routerName, method, path, err)
+			return
+		}
+		log.DebugLevel().Printf("Route (%v, %v, %v) rules changed.", routerName, method, path)
+	}()
+	path, err = checkRoute(routerName, method, path, "")
+	if err != nil {
+		return
+	}
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return
+	}
+
+	key := routeKey{router: routerName, method: method, path: path}
+
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+
+	entry, found := r.table[key]
+	if !found || !entry.active {
+		err = e.New("route not found")
+		return
+	}
+	entry.rules = compiled
+	entry.opts.Rules = rulesOf(compiled)
+	r.save(key, entry)
+	return
+}
+
+// rulesOf returns the rules in the order of evaluation, nil if there is none.
+func rulesOf(compiled []*rule) []*Rule {
+	if len(compiled) == 0 {
+		return nil
+	}
+	rules := make([]*Rule, 0, len(compiled))
+	for _, rl := range compiled {
+		rules = append(rules, rl.Rule)
+	}
+	return rules
+}
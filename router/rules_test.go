@@ -0,0 +1,216 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestRuleMatch(t *testing.T) {
+	tests := []struct {
+		rule   Rule
+		req    func() *http.Request
+		result bool
+	}{
+		{Rule{}, func() *http.Request {
+			return httptest.NewRequest("GET", "http://localhost/", nil)
+		}, true},
+		{Rule{Headers: map[string]string{"X-Api-Version": "2"}}, func() *http.Request {
+			req := httptest.NewRequest("GET", "http://localhost/", nil)
+			req.Header.Set("X-Api-Version", "2")
+			return req
+		}, true},
+		{Rule{Headers: map[string]string{"x-api-version": "2"}}, func() *http.Request {
+			req := httptest.NewRequest("GET", "http://localhost/", nil)
+			req.Header.Set("X-Api-Version", "1")
+			return req
+		}, false},
+		{Rule{Headers: map[string]string{"X-Api-Version": ""}}, func() *http.Request {
+			return httptest.NewRequest("GET", "http://localhost/", nil)
+		}, false},
+		{Rule{Headers: map[string]string{"Accept": "application/grpc"}}, func() *http.Request {
+			req := httptest.NewRequest("GET", "http://localhost/", nil)
+			req.Header.Set("Accept", "text/html, application/grpc;q=0.9")
+			return req
+		}, true},
+		{Rule{Query: map[string]string{"beta": ""}}, func() *http.Request {
+			return httptest.NewRequest("GET", "http://localhost/?beta=1", nil)
+		}, true},
+		{Rule{Query: map[string]string{"beta": "2"}}, func() *http.Request {
+			return httptest.NewRequest("GET", "http://localhost/?beta=1", nil)
+		}, false},
+		{Rule{Cookies: map[string]string{"group": "a"}}, func() *http.Request {
+			req := httptest.NewRequest("GET", "http://localhost/", nil)
+			req.AddCookie(&http.Cookie{Name: "group", Value: "a"})
+			return req
+		}, true},
+		{Rule{Cookies: map[string]string{"group": "a"}}, func() *http.Request {
+			return httptest.NewRequest("GET", "http://localhost/", nil)
+		}, false},
+		{Rule{CIDRs: []string{"10.0.0.0/8", "192.0.2.0/24"}}, func() *http.Request {
+			req := httptest.NewRequest("GET", "http://localhost/", nil)
+			req.RemoteAddr = "192.0.2.7:1234"
+			return req
+		}, true},
+		{Rule{CIDRs: []string{"10.0.0.0/8"}}, func() *http.Request {
+			req := httptest.NewRequest("GET", "http://localhost/", nil)
+			req.RemoteAddr = "192.0.2.7:1234"
+			return req
+		}, false},
+		{Rule{ContentType: "application/json"}, func() *http.Request {
+			req := httptest.NewRequest("POST", "http://localhost/", nil)
+			req.Header.Set("Content-Type", "application/JSON; charset=utf-8")
+			return req
+		}, true},
+		{Rule{ContentType: "application/json", Headers: map[string]string{"X-Api-Version": "2"}}, func() *http.Request {
+			req := httptest.NewRequest("POST", "http://localhost/", nil)
+			req.Header.Set("Content-Type", "application/json")
+			return req
+		}, false},
+	}
+	for i, test := range tests {
+		rules, err := compileRules([]*Rule{&test.rule})
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if r := rules[0].match(test.req()); r != test.result {
+			t.Fatal(i, "wrong match", r)
+		}
+	}
+}
+
+func TestCompileRules(t *testing.T) {
+	_, err := compileRules([]*Rule{{CIDRs: []string{"10.0.0.0"}}})
+	if err == nil || !e.Contains(err, "invalid rule cidr") {
+		t.Fatal("wrong error", err)
+	}
+	_, err = compileRules([]*Rule{{ContentType: "/json"}})
+	if err == nil || !e.Contains(err, "invalid rule content type") {
+		t.Fatal("wrong error", err)
+	}
+	rules, err := compileRules([]*Rule{
+		{Tag: "a"},
+		{Tag: "b", Priority: 10},
+		{Tag: "c"},
+		{Tag: "d", Priority: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := ""
+	for _, rl := range rules {
+		order += rl.Tag
+	}
+	if order != "bdac" {
+		t.Fatal("wrong order", order)
+	}
+}
+
+func TestRules(t *testing.T) {
+	HTTPClient = http.DefaultClient
+	servers := make(map[string]string)
+	for _, tag := range []string{"", "grpc", "v2"} {
+		tag := tag
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("server" + tag))
+		}))
+		defer server.Close()
+		servers[tag] = server.URL
+	}
+
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	for tag, addr := range servers {
+		err = r.Register(&Route{
+			Methode: "GET",
+			Router:  DefaultRouter,
+			Path:    "/rules",
+			RedirTo: addr,
+			Tag:     tag,
+			Rules: []*Rule{
+				{Headers: map[string]string{"Accept": "application/grpc"}, Tag: "grpc"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(header, val string) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost/en/rules", nil)
+		if header != "" {
+			req.Header.Set(header, val)
+		}
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatal("wrong status code", w.Code)
+		}
+		return w.Body.String()
+	}
+
+	// The requests without a matching rule stay in the untagged servers.
+	for i := 0; i < 6; i++ {
+		if s := get("", ""); s != "server" {
+			t.Fatal("wrong server", s)
+		}
+	}
+	if s := get("Accept", "application/grpc"); s != "servergrpc" {
+		t.Fatal("wrong server", s)
+	}
+
+	err = r.SetRules(DefaultRouter, "GET", "/rules", []*Rule{
+		{Headers: map[string]string{"Accept": "application/grpc"}, Tag: "grpc"},
+		{Headers: map[string]string{"X-Api-Version": "2"}, Tag: "v2"},
+		// No server with the tag, the next rule is used.
+		{Headers: map[string]string{"X-Api-Version": "2"}, Tag: "v3", Priority: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := get("X-Api-Version", "2"); s != "serverv2" {
+		t.Fatal("wrong server", s)
+	}
+	for i := 0; i < 6; i++ {
+		if s := get("X-Api-Version", "1"); s != "server" {
+			t.Fatal("wrong server", s)
+		}
+	}
+
+	routes, err := r.Get(DefaultRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range routes {
+		if route.Path != "/rules" {
+			continue
+		}
+		if len(route.Rules) != 3 || route.Rules[0].Tag != "v3" {
+			t.Fatal("wrong rules", route.Rules)
+		}
+	}
+
+	err = r.SetRules(DefaultRouter, "GET", "/rules", []*Rule{{CIDRs: []string{"blurf"}}})
+	if err == nil || !e.Contains(err, "invalid rule cidr") {
+		t.Fatal("wrong error", err)
+	}
+	err = r.SetRules(DefaultRouter, "GET", "/rules", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := get("Accept", "application/grpc"); s != "server" {
+		t.Fatal("wrong server", s)
+	}
+}
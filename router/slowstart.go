@@ -0,0 +1,158 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SlowStartMinPercent is the percent of its share of the requests that a
+// server receives when it is added.
+var SlowStartMinPercent = 10
+
+// slowStartPicks is the number of times SlowStart asks the LoadBalance for
+// another server when the one returned is warming up.
+const slowStartPicks = 3
+
+// SlowStart wraps a LoadBalance and ramps up the requests sent to the servers
+// added, from SlowStartMinPercent to the full share when the window ends.
+// The servers readmitted by the health check or by OutlierDetection warm up
+// again.
+type SlowStart struct {
+	LoadBalance
+	window time.Duration
+	// added is when the server was added and pools are its routes.
+	added map[string]time.Time
+	pools map[string]map[pool]struct{}
+	lck   sync.Mutex
+}
+
+// NewSlowStart adds the slow start to lb.
+func NewSlowStart(lb LoadBalance, window time.Duration) *SlowStart {
+	return &SlowStart{
+		LoadBalance: lb,
+		window:      window,
+		added:       make(map[string]time.Time),
+		pools:       make(map[string]map[pool]struct{}),
+	}
+}
+
+// Clone returns a new slow start with the same window for a clone of the
+// LoadBalance.
+func (ss *SlowStart) Clone() LoadBalance {
+	return NewSlowStart(clone(ss.LoadBalance), ss.window)
+}
+
+// AddAddrs adds ip to the LoadBalance and starts the warm up if ip isn't in
+// other route.
+func (ss *SlowStart) AddAddrs(method, path, ip string) {
+	ss.lck.Lock()
+	pools, found := ss.pools[ip]
+	if !found {
+		pools = make(map[pool]struct{})
+		ss.pools[ip] = pools
+		ss.added[ip] = time.Now()
+	}
+	pools[pool{method, path}] = struct{}{}
+	ss.lck.Unlock()
+	ss.LoadBalance.AddAddrs(method, path, ip)
+}
+
+// Remove removes ip from the LoadBalance.
+func (ss *SlowStart) Remove(method, path, ip string) {
+	ss.lck.Lock()
+	if pools, found := ss.pools[ip]; found {
+		delete(pools, pool{method, path})
+		if len(pools) == 0 {
+			delete(ss.pools, ip)
+			delete(ss.added, ip)
+		}
+	}
+	ss.lck.Unlock()
+	ss.LoadBalance.Remove(method, path, ip)
+}
+
+// Next returns the next address, skipping the servers warming up in
+// proportion to the time left.
+func (ss *SlowStart) Next(method, path string) string {
+	return ss.pick(method, path, func() string {
+		return ss.LoadBalance.Next(method, path)
+	})
+}
+
+// NextRequest uses the NextRequest of the LoadBalance if it has one.
+func (ss *SlowStart) NextRequest(req *http.Request, method, path string) string {
+	rlb, ok := ss.LoadBalance.(RequestLoadBalance)
+	if !ok {
+		return ss.Next(method, path)
+	}
+	return ss.pick(method, path, func() string {
+		return rlb.NextRequest(req, method, path)
+	})
+}
+
+func (ss *SlowStart) pick(method, path string, next func() string) string {
+	dst := next()
+	for i := 0; dst != "" && i < slowStartPicks && !ss.accept(dst); i++ {
+		ss.LoadBalance.Done(method, path, dst)
+		dst = next()
+	}
+	return dst
+}
+
+// accept returns true if the request can go to ip.
+func (ss *SlowStart) accept(ip string) bool {
+	f := ss.factor(ip)
+	return f >= 1 || rand.Float64() < f
+}
+
+// factor returns the fraction of the share of the requests of ip, between
+// SlowStartMinPercent/100 and 1.
+func (ss *SlowStart) factor(ip string) float64 {
+	ss.lck.Lock()
+	defer ss.lck.Unlock()
+	added, found := ss.added[ip]
+	if !found || ss.window <= 0 {
+		return 1
+	}
+	elapsed := time.Since(added)
+	if elapsed >= ss.window {
+		return 1
+	}
+	f := float64(elapsed) / float64(ss.window)
+	if min := float64(SlowStartMinPercent) / 100; f < min {
+		f = min
+	}
+	return f
+}
+
+// SetWeight sets the weight if the LoadBalance is a Weighter.
+func (ss *SlowStart) SetWeight(method, path, ip string, weight int) {
+	if w, ok := ss.LoadBalance.(Weighter); ok {
+		w.SetWeight(method, path, ip, weight)
+	}
+}
+
+// Report sends the response code to the LoadBalance if it is a Reporter.
+func (ss *SlowStart) Report(method, path, ip string, code int) {
+	if r, ok := ss.LoadBalance.(Reporter); ok {
+		r.Report(method, path, ip, code)
+	}
+}
+
+// Contains returns true if ip is in the LoadBalance.
+func (ss *SlowStart) Contains(method, path, ip string) bool {
+	return contains(ss.LoadBalance, method, path, ip)
+}
+
+func (ss *SlowStart) addrs(method, path string) []string {
+	if a, ok := ss.LoadBalance.(addrser); ok {
+		return a.addrs(method, path)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlowStart(t *testing.T) {
+	ss := NewSlowStart(NewRoundRobin(), time.Hour)
+	ss.AddAddrs("GET", "/", "10.0.1.1")
+	ss.added["10.0.1.1"] = time.Now().Add(-2 * time.Hour)
+	ss.AddAddrs("GET", "/", "10.0.1.2")
+
+	if f := ss.factor("10.0.1.1"); f != 1 {
+		t.Fatal("wrong factor", f)
+	}
+	if f := ss.factor("10.0.1.2"); f != float64(SlowStartMinPercent)/100 {
+		t.Fatal("wrong factor", f)
+	}
+
+	count := 0
+	for i := 0; i < 1000; i++ {
+		if ss.Next("GET", "/") == "10.0.1.2" {
+			count++
+		}
+	}
+	// Without slow start the new server would have 500 requests.
+	if count > 200 {
+		t.Fatal("too many requests to the new server", count)
+	}
+
+	// Half of the window.
+	ss.added["10.0.1.2"] = time.Now().Add(-30 * time.Minute)
+	if f := ss.factor("10.0.1.2"); f < 0.49 || f > 0.51 {
+		t.Fatal("wrong factor", f)
+	}
+
+	// Adding again to other route doesn't restart the warm up.
+	ss.AddAddrs("POST", "/", "10.0.1.2")
+	if f := ss.factor("10.0.1.2"); f < 0.49 {
+		t.Fatal("warm up restarted", f)
+	}
+
+	// Removed from all routes and added again it warms up again.
+	ss.Remove("GET", "/", "10.0.1.2")
+	ss.Remove("POST", "/", "10.0.1.2")
+	ss.AddAddrs("GET", "/", "10.0.1.2")
+	if f := ss.factor("10.0.1.2"); f > 0.11 {
+		t.Fatal("warm up not restarted", f)
+	}
+}
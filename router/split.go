@@ -0,0 +1,204 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/fcavani/droute/responsewriter"
+	"github.com/fcavani/e"
+	log "github.com/fcavani/slog"
+)
+
+// SplitPolicy splits the requests of a route between the versions of the
+// servers, the servers with the same tag. The requests that match an override
+// go to its tag, the others are split by the weights. If the tag selected has
+// no server the request goes to the untagged servers of the route.
+type SplitPolicy struct {
+	// Weights of the tags, the requests are split in proportion to them. The
+	// untagged servers have the empty tag. Tags not listed only receive the
+	// requests that match an override.
+	Weights map[string]int
+	// Overrides are checked in order before the weights.
+	Overrides []SplitOverride `json:",omitempty"`
+}
+
+// SplitOverride sends the requests with a header or a cookie to the servers
+// with the tag Tag.
+type SplitOverride struct {
+	// Header or Cookie is the name of the header or the cookie.
+	Header string `json:",omitempty"`
+	Cookie string `json:",omitempty"`
+	// Value of the header or cookie. Empty matches any value.
+	Value string `json:",omitempty"`
+	Tag   string
+}
+
+func (so *SplitOverride) match(req *http.Request) bool {
+	var val string
+	if so.Header != "" {
+		val = req.Header.Get(so.Header)
+	} else if c, err := req.Cookie(so.Cookie); err == nil {
+		val = c.Value
+	}
+	if val == "" {
+		return false
+	}
+	return so.Value == "" || so.Value == val
+}
+
+// checkSplit validates the split policy.
+func checkSplit(sp *SplitPolicy) error {
+	if sp == nil {
+		return nil
+	}
+	for _, w := range sp.Weights {
+		if w < 0 {
+			return e.New("invalid split weight")
+		}
+	}
+	for _, o := range sp.Overrides {
+		if (o.Header == "") == (o.Cookie == "") {
+			return e.New("split override needs a header or a cookie")
+		}
+	}
+	return nil
+}
+
+// pick returns the tag of the request, false if the request can go to any
+// server.
+func (sp *SplitPolicy) pick(req *http.Request) (string, bool) {
+	for _, o := range sp.Overrides {
+		if o.match(req) {
+			return o.Tag, true
+		}
+	}
+	tags := make([]string, 0, len(sp.Weights))
+	total := 0
+	for tag, w := range sp.Weights {
+		if w <= 0 {
+			continue
+		}
+		tags = append(tags, tag)
+		total += w
+	}
+	if total == 0 {
+		return "", false
+	}
+	sort.Strings(tags)
+	n := rand.Intn(total)
+	for _, tag := range tags {
+		n -= sp.Weights[tag]
+		if n < 0 {
+			return tag, true
+		}
+	}
+	return "", false
+}
+
+// SetSplit changes the split policy of the route without registering the
+// servers again. Nil removes the split.
+func (r *Router) SetSplit(routerName, method, path string, sp *SplitPolicy) (err error) {
+	defer func() {
+		if err != nil {
+			log.Errorf("Can't split (%v, %v, %v) error: %v", routerName, method, path, err)
+			return
+		}
+		log.DebugLevel().Printf("Route (%v, %v, %v) split changed.", routerName, method, path)
+	}()
+	path, err = checkRoute(routerName, method, path, "")
+	if err != nil {
+		return
+	}
+	err = checkSplit(sp)
+	if err != nil {
+		return
+	}
+
+	key := routeKey{router: routerName, method: method, path: path}
+
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+
+	entry, found := r.table[key]
+	if !found || !entry.active {
+		err = e.New("route not found")
+		return
+	}
+	if sp != nil {
+		cp := *sp
+		sp = &cp
+	}
+	entry.split = sp
+	entry.opts.Split = sp
+	r.save(key, entry)
+	return
+}
+
+// tagBalance sends the request to the servers of the tag picked by the rules
+// or by the split policy of the route, or to the untagged servers in lb.
+func (r *Router) tagBalance(key routeKey, lb LoadBalance, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	def := StickyBalance(lb, r.affinity, handler)
+	handlers := make(map[string]responsewriter.HandlerFunc)
+	var lck sync.Mutex
+	return func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		var tlb LoadBalance
+		var tag string
+		r.tableLck.RLock()
+		if entry, found := r.table[key]; found {
+			tag, tlb = entry.pool(key, req)
+		}
+		r.tableLck.RUnlock()
+		if tlb == nil {
+			def(rw, req)
+			return
+		}
+		lck.Lock()
+		h, found := handlers[tag]
+		if !found {
+			h = StickyBalance(tlb, r.affinity, handler)
+			handlers[tag] = h
+		}
+		lck.Unlock()
+		h(rw, req)
+	}
+}
+
+// pool returns the tag and the LoadBalance of the servers that receive the
+// request, nil if the untagged servers receive it. The rules go before the
+// split policy and the tags without servers are skipped.
+func (re *routeEntry) pool(key routeKey, req *http.Request) (string, LoadBalance) {
+	for _, rl := range re.rules {
+		if !rl.match(req) {
+			continue
+		}
+		if lb := re.serving(key, rl.Tag); lb != nil {
+			return rl.Tag, lb
+		}
+	}
+	if re.split == nil {
+		return "", nil
+	}
+	tag, ok := re.split.pick(req)
+	if !ok {
+		return "", nil
+	}
+	return tag, re.serving(key, tag)
+}
+
+// serving returns the LoadBalance of the tag if it has servers.
+func (re *routeEntry) serving(key routeKey, tag string) LoadBalance {
+	lb, found := re.tags[tag]
+	if !found {
+		return nil
+	}
+	if a, ok := lb.(addrser); ok && len(a.addrs(key.method, key.path)) == 0 {
+		return nil
+	}
+	return lb
+}
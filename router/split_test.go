@@ -0,0 +1,173 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestSplitPick(t *testing.T) {
+	sp := &SplitPolicy{
+		Weights: map[string]int{"v1": 95, "v2": 5, "v3": 0},
+		Overrides: []SplitOverride{
+			{Header: "X-Canary", Value: "1", Tag: "v2"},
+			{Cookie: "version", Tag: "v3"},
+		},
+	}
+	count := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		req := httptest.NewRequest("GET", "http://localhost/", nil)
+		tag, ok := sp.pick(req)
+		if !ok {
+			t.Fatal("no tag")
+		}
+		count[tag]++
+	}
+	if count["v3"] != 0 || count["v2"] < 300 || count["v2"] > 700 {
+		t.Fatal("wrong split", count)
+	}
+
+	req := httptest.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("X-Canary", "1")
+	if tag, _ := sp.pick(req); tag != "v2" {
+		t.Fatal("wrong tag", tag)
+	}
+	req = httptest.NewRequest("GET", "http://localhost/", nil)
+	req.Header.Set("X-Canary", "0")
+	req.AddCookie(&http.Cookie{Name: "version", Value: "any"})
+	if tag, _ := sp.pick(req); tag != "v3" {
+		t.Fatal("wrong tag", tag)
+	}
+
+	sp = &SplitPolicy{Weights: map[string]int{"v1": 0}}
+	if _, ok := sp.pick(req); ok {
+		t.Fatal("tag picked")
+	}
+}
+
+func TestCheckSplit(t *testing.T) {
+	err := checkSplit(&SplitPolicy{Weights: map[string]int{"v1": -1}})
+	if err == nil || !e.Contains(err, "invalid split weight") {
+		t.Fatal("wrong error", err)
+	}
+	err = checkSplit(&SplitPolicy{Overrides: []SplitOverride{{Tag: "v2"}}})
+	if err == nil || !e.Contains(err, "needs a header or a cookie") {
+		t.Fatal("wrong error", err)
+	}
+	err = checkSplit(&SplitPolicy{Overrides: []SplitOverride{{Header: "X-Canary", Cookie: "canary", Tag: "v2"}}})
+	if err == nil || !e.Contains(err, "needs a header or a cookie") {
+		t.Fatal("wrong error", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	HTTPClient = http.DefaultClient
+	servers := make(map[string]string)
+	for _, tag := range []string{"", "v1", "v2"} {
+		tag := tag
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(tag))
+		}))
+		defer server.Close()
+		servers[tag] = server.URL
+	}
+
+	r := &Router{}
+	err := r.Start(NewRouters(), NewRoundRobin(), time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	for tag, addr := range servers {
+		err = r.Register(&Route{
+			Methode: "GET",
+			Router:  DefaultRouter,
+			Path:    "/split",
+			RedirTo: addr,
+			Tag:     tag,
+			Split: &SplitPolicy{
+				Weights:   map[string]int{"v1": 1},
+				Overrides: []SplitOverride{{Header: "X-Canary", Value: "1", Tag: "v2"}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get := func(canary bool) map[string]int {
+		count := make(map[string]int)
+		for i := 0; i < 20; i++ {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://localhost/en/split", nil)
+			if canary {
+				req.Header.Set("X-Canary", "1")
+			}
+			r.ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatal("wrong status code", w.Code)
+			}
+			count[w.Body.String()]++
+		}
+		return count
+	}
+
+	if count := get(false); count["v1"] != 20 {
+		t.Fatal("wrong split", count)
+	}
+	if count := get(true); count["v2"] != 20 {
+		t.Fatal("wrong split", count)
+	}
+
+	// Change the split at runtime.
+	err = r.SetSplit(DefaultRouter, "GET", "/split", &SplitPolicy{Weights: map[string]int{"v2": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := get(false); count["v2"] != 20 {
+		t.Fatal("wrong split", count)
+	}
+
+	// A tag without servers uses the untagged servers.
+	err = r.SetSplit(DefaultRouter, "GET", "/split", &SplitPolicy{Weights: map[string]int{"v3": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := get(false); count[""] != 20 {
+		t.Fatal("wrong split", count)
+	}
+
+	// A removed server leaves its tag too.
+	err = r.SetSplit(DefaultRouter, "GET", "/split", &SplitPolicy{Weights: map[string]int{"v2": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Del(DefaultRouter, "GET", "/split", servers["v2"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := get(false); count[""] != 20 {
+		t.Fatal("wrong split", count)
+	}
+
+	// Without split the tagged servers don't receive requests.
+	err = r.SetSplit(DefaultRouter, "GET", "/split", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := get(false); count[""] != 20 {
+		t.Fatal("wrong split", count)
+	}
+	err = r.SetSplit(DefaultRouter, "GET", "/nosplit", nil)
+	if err == nil || !e.Contains(err, "route not found") {
+		t.Fatal("wrong error", err)
+	}
+}
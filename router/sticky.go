@@ -0,0 +1,71 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/fcavani/e"
+	"github.com/gorilla/securecookie"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+// AffinityCookie is the signed cookie that names the server of the client.
+type AffinityCookie struct {
+	*http.Cookie
+	*securecookie.SecureCookie
+}
+
+// Backend returns the server in the cookie or an empty string if the cookie
+// doesn't exist or is invalid.
+func (c *AffinityCookie) Backend(r *http.Request) string {
+	cookie, err := r.Cookie(c.Cookie.Name)
+	if err != nil {
+		return ""
+	}
+	var dst string
+	err = c.Decode(c.Cookie.Name, cookie.Value, &dst)
+	if err != nil {
+		return ""
+	}
+	return dst
+}
+
+// Set sets the cookie with the server dst.
+func (c *AffinityCookie) Set(w http.ResponseWriter, dst string) error {
+	val, err := c.Encode(c.Cookie.Name, dst)
+	if err != nil {
+		return e.Forward(err)
+	}
+	cookie := &http.Cookie{
+		Name:     c.Cookie.Name,
+		Value:    val,
+		Path:     c.Cookie.Path,
+		Domain:   c.Cookie.Domain,
+		MaxAge:   c.Cookie.MaxAge,
+		Secure:   c.Cookie.Secure,
+		HttpOnly: c.Cookie.HttpOnly,
+	}
+	if c.Cookie.MaxAge > 0 {
+		cookie.Expires = time.Now().Add(time.Duration(c.Cookie.MaxAge) * time.Second).UTC()
+	}
+	http.SetCookie(w, cookie)
+	return nil
+}
+
+// StickyBalance is like Balance but sends the client to the server in the
+// affinity cookie while that server is in the LoadBalance. The LoadBalance
+// must implement Container. If the server isn't in the LoadBalance anymore, it
+// was removed or it is down, a new one is chosen and the cookie is updated.
+func StickyBalance(lb LoadBalance, affinity *AffinityCookie, handler responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+	return balance(lb, affinity, handler)
+}
+
+// Affinity enables the sticky sessions in the routes added after this call.
+func (r *Router) Affinity(c *AffinityCookie) {
+	r.affinity = c
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+func TestStickyBalance(t *testing.T) {
+	affinity := &AffinityCookie{
+		Cookie: &http.Cookie{
+			Name:     "droute",
+			Path:     "/",
+			HttpOnly: true,
+		},
+		SecureCookie: securecookie.New(securecookie.GenerateRandomKey(64), nil),
+	}
+
+	rr := NewRoundRobin()
+	rr.AddAddrs("GET", "/", "10.0.1.1")
+	rr.AddAddrs("GET", "/", "10.0.1.2")
+	rr.AddAddrs("GET", "/", "10.0.1.3")
+
+	var dst string
+	h := StickyBalance(rr, affinity, func(rw *responsewriter.ResponseWriter, req *http.Request) {
+		dst = req.Context().Value("proxyredirdst").(string)
+		rw.WriteHeader(200)
+	})
+
+	// First request without cookie.
+	req, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := responsewriter.NewResponseWriter()
+	h(rw, req)
+	if dst != "10.0.1.1" {
+		t.Fatal("wrong destiny", dst)
+	}
+	resp := http.Response{Header: rw.Header()}
+	cookies := resp.Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "droute" {
+		t.Fatal("affinity cookie not set")
+	}
+	cookie := cookies[0]
+
+	// With the cookie is always the same server.
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequest("GET", "http://localhost/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(cookie)
+		rw := responsewriter.NewResponseWriter()
+		h(rw, req)
+		if dst != "10.0.1.1" {
+			t.Fatal("wrong destiny", dst)
+		}
+		if c := rw.Header().Get("Set-Cookie"); c != "" {
+			t.Fatal("cookie set again", c)
+		}
+	}
+
+	// Forged cookie is ignored.
+	req, err = http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(&http.Cookie{Name: "droute", Value: "10.0.1.3"})
+	h(responsewriter.NewResponseWriter(), req)
+	if dst != "10.0.1.2" {
+		t.Fatal("wrong destiny", dst)
+	}
+
+	// Server removed, fall back to the load balance.
+	rr.Remove("GET", "/", "10.0.1.1")
+	req, err = http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie)
+	rw = responsewriter.NewResponseWriter()
+	h(rw, req)
+	if dst == "10.0.1.1" || dst == "" {
+		t.Fatal("wrong destiny", dst)
+	}
+	resp = http.Response{Header: rw.Header()}
+	cookies = resp.Cookies()
+	if len(cookies) != 1 || affinity.Backend(requestWithCookie(t, cookies[0])) != dst {
+		t.Fatal("affinity cookie not updated")
+	}
+}
+
+func requestWithCookie(t *testing.T, c *http.Cookie) *http.Request {
+	req, err := http.NewRequest("GET", "http://localhost/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(c)
+	return req
+}
@@ -0,0 +1,140 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"github.com/fcavani/e"
+	log "github.com/fcavani/slog"
+)
+
+// RouteStore keeps the routes of the router between restarts, see SetStore.
+// The routes are stored with their proxy options and their servers in
+// Route.Backends.
+type RouteStore interface {
+	// Put stores the route, replacing the previous one with the same router,
+	// method and path.
+	Put(route *Route) error
+	// Del removes the route.
+	Del(routerName, method, path string) error
+	// Routes returns all routes stored.
+	Routes() (Routes, error)
+	// Close the store.
+	Close() error
+}
+
+// SetStore sets the store where the changes in the routes are saved, see
+// Restore. If unverified is true the restored servers don't receive requests
+// until they pass their first health check, Restore fails if HealthCheck isn't
+// called before it.
+func (r *Router) SetStore(store RouteStore, unverified bool) {
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+	r.store = store
+	r.restoreUnverified = unverified
+}
+
+// Restore adds the routes in the store to the router, the leases of the
+// restored servers start again. The handlers of the routes are built when
+// they are added, so call it after Start and after the router is configured,
+// like Middlewares, TrustForwardHeaders, SetRetryPolicy, SetBalancer,
+// SetBreakerSettings, Affinity and HealthCheck.
+func (r *Router) Restore() error {
+	r.tableLck.RLock()
+	store, unverified, hc := r.store, r.restoreUnverified, r.hc
+	r.tableLck.RUnlock()
+	if store == nil {
+		return e.New("no store")
+	}
+	if unverified && hc == nil {
+		return e.New("unverified restore without health check")
+	}
+	routes, err := store.Routes()
+	if err != nil {
+		return e.Push(err, "can't restore the routes")
+	}
+	for _, route := range routes {
+		for _, b := range route.Backends {
+			rt := *route
+			rt.RedirTo = b.Addr
+			rt.TTL = b.TTL
+			rt.Weight = b.Weight
+			rt.Tag = b.Tag
+			rt.Backends = nil
+			if unverified {
+				r.tableLck.Lock()
+				r.unverified[b.Addr] = struct{}{}
+				r.tableLck.Unlock()
+			}
+			// The route is already in the store.
+			err = r.register(&rt, false)
+			if err != nil {
+				log.Tag("router", "store").Errorf("Can't restore route (%v, %v, %v => %v): %v", route.Router, route.Methode, route.Path, b.Addr, err)
+			}
+		}
+	}
+	log.Tag("router", "store").Printf("%v routes restored.", len(routes))
+	return nil
+}
+
+// save stores the route key, or removes it from the store if it has no
+// servers. tableLck must be locked.
+func (r *Router) save(key routeKey, entry *routeEntry) {
+	if r.store == nil {
+		return
+	}
+	var err error
+	route := r.stored(key, entry)
+	if len(route.Backends) == 0 {
+		err = r.store.Del(key.router, key.method, key.path)
+	} else {
+		err = r.store.Put(route)
+	}
+	if err != nil {
+		log.Tag("router", "store").Errorf("Can't save route (%v, %v, %v): %v", key.router, key.method, key.path, err)
+	}
+}
+
+// stored returns the route as it goes to the store, the draining servers are
+// left out. tableLck must be locked.
+func (r *Router) stored(key routeKey, entry *routeEntry) *Route {
+	route := *entry.opts
+	route.Router = key.router
+	route.Methode = key.method
+	route.Path = key.path
+	route.RedirTo = ""
+	route.TTL = 0
+	route.Weight = 0
+	route.Tag = ""
+	route.MirrorStats = nil
+	route.Backends = nil
+	if route.Balancer == "" {
+		route.Balancer = r.balancers[key.router]
+	}
+	if !entry.active {
+		return &route
+	}
+	for _, b := range entry.dsts {
+		if !b.draining.IsZero() {
+			continue
+		}
+		route.Backends = append(route.Backends, &Backend{
+			Addr:   b.addr,
+			Weight: b.weight,
+			Tag:    b.tag,
+			TTL:    b.ttl,
+		})
+	}
+	return &route
+}
+
+// verified returns false if the server addr was restored and didn't pass a
+// health check yet. tableLck must be locked.
+func (r *Router) verified(addr string) bool {
+	if r.hc == nil {
+		return true
+	}
+	_, found := r.unverified[addr]
+	return !found
+}
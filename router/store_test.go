@@ -0,0 +1,240 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+	"github.com/fcavani/e"
+)
+
+func openStore(t *testing.T) (*BoltStore, func()) {
+	dir, err := ioutil.TempDir("", "routes_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenBoltStore(filepath.Join(dir, "routes.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	s, done := openStore(t)
+	defer done()
+
+	err := s.Put(&Route{Methode: "GET", Router: DefaultRouter, Path: "/b", Backends: []*Backend{{Addr: "http://10.0.0.1", Weight: 2}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Put(&Route{Methode: "GET", Router: DefaultRouter, Path: "/a", Backends: []*Backend{{Addr: "http://10.0.0.1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replaces the route.
+	err = s.Put(&Route{Methode: "GET", Router: DefaultRouter, Path: "/b", Backends: []*Backend{{Addr: "http://10.0.0.2", Tag: "v2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err := s.Routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 || routes[0].Path != "/a" || routes[1].Path != "/b" {
+		t.Fatal("wrong routes", routes)
+	}
+	if bs := routes[1].Backends; len(bs) != 1 || bs[0].Addr != "http://10.0.0.2" || bs[0].Tag != "v2" {
+		t.Fatal("wrong backends", bs)
+	}
+
+	err = s.Del(DefaultRouter, "GET", "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Del(DefaultRouter, "GET", "/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes, err = s.Routes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 1 || routes[0].Path != "/b" {
+		t.Fatal("wrong routes", routes)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	HTTPClient = http.DefaultClient
+
+	s, done := openStore(t)
+	defer done()
+
+	r := &Router{}
+	r.SetStore(s, false)
+	err := r.Start(NewRouters(), NewWeightedRoundRobin(), time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Register(&Route{Methode: "GET", Router: DefaultRouter, Path: "/s", RedirTo: server.URL, Tag: "v1", TTL: time.Hour, Weight: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Add(DefaultRouter, "GET", "/s", "http://10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Add(DefaultRouter, "GET", "/s", "http://10.0.0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Del(DefaultRouter, "GET", "/s", "http://10.0.0.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.SetWeight(DefaultRouter, "GET", "/s", "http://10.0.0.3", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.SetSplit(DefaultRouter, "GET", "/s", &SplitPolicy{Weights: map[string]int{"v1": 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Add(DefaultRouter, "GET", "/gone", "http://10.0.0.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.DelRoute(DefaultRouter, "GET", "/gone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Stop()
+
+	r = &Router{}
+	r.SetStore(s, true)
+	err = r.Start(NewRouters(), NewWeightedRoundRobin(), time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	err = r.Restore()
+	if err == nil || !e.Contains(err, "unverified restore without health check") {
+		t.Fatal("wrong error", err)
+	}
+	// The health check is set after the store.
+	hc := &HealthCheck{Interval: time.Hour, Healthy: 2}
+	err = r.HealthCheck(hc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Middlewares(func(last responsewriter.HandlerFunc) responsewriter.HandlerFunc {
+		return func(rw *responsewriter.ResponseWriter, req *http.Request) {
+			rw.Header().Set("X-Middleware", "restored")
+			last(rw, req)
+		}
+	})
+	err = r.Restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	routes, err := r.Get(DefaultRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var route *Route
+	for _, rt := range routes {
+		if rt.Path == "/gone" {
+			t.Fatal("removed route restored")
+		}
+		if rt.Path == "/s" {
+			route = rt
+		}
+	}
+	if route == nil {
+		t.Fatal("route not restored")
+	}
+	if route.Split == nil || route.Split.Weights["v1"] != 1 {
+		t.Fatal("split not restored", route.Split)
+	}
+	if len(route.Backends) != 2 {
+		t.Fatal("wrong backends", route.Backends)
+	}
+	for _, b := range route.Backends {
+		if b.Health != HealthUnverified {
+			t.Fatal("wrong health", b.Addr, b.Health)
+		}
+		switch b.Addr {
+		case server.URL:
+			if b.Weight != 3 || b.Tag != "v1" {
+				t.Fatal("wrong backend", b)
+			}
+		case "http://10.0.0.3":
+			if b.Weight != 2 {
+				t.Fatal("wrong backend", b)
+			}
+		default:
+			t.Fatal("wrong backend", b.Addr)
+		}
+	}
+	r.tableLck.RLock()
+	expire := r.table[routeKey{router: DefaultRouter, method: "GET", path: "/s"}].get(server.URL).expire
+	r.tableLck.RUnlock()
+	if expire.IsZero() {
+		t.Fatal("lease not restored")
+	}
+
+	next := func() string {
+		r.tableLck.RLock()
+		defer r.tableLck.RUnlock()
+		key := routeKey{router: DefaultRouter, method: "GET", path: "/s"}
+		lb := r.table[key].serving(key, "v1")
+		if lb == nil {
+			return ""
+		}
+		return lb.Next("GET", "/s")
+	}
+	if dst := next(); dst != "" {
+		t.Fatal("unverified server in the load balance", dst)
+	}
+	r.check(hc)
+	if dst := next(); dst != server.URL {
+		t.Fatal("verified server not in the load balance", dst)
+	}
+
+	// The handler of the restored route has the middlewares set before
+	// Restore.
+	req, err := http.NewRequest("GET", "http://localhost/en/s", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK || rw.Header().Get("X-Middleware") != "restored" {
+		t.Fatal("wrong response", rw.Code, rw.Header())
+	}
+	r.tableLck.RLock()
+	health := r.healthOf("http://10.0.0.3")
+	r.tableLck.RUnlock()
+	if health != HealthUnverified {
+		t.Fatal("wrong health", health)
+	}
+}
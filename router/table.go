@@ -0,0 +1,138 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// routeKey identify one proxied route in the router.
+type routeKey struct {
+	router string
+	method string
+	path   string
+}
+
+// backend is one server of a route.
+type backend struct {
+	addr string
+	// expire is when the lease ends. Zero means no lease.
+	expire time.Time
+	// ttl is the duration of the lease.
+	ttl    time.Duration
+	weight int
+	// draining is the deadline of the drain. Zero if not draining.
+	draining time.Time
+	// inflight is the number of requests in flight. Use atomic.
+	inflight int32
+	// tag of the server, see Route.Tag.
+	tag string
+}
+
+// lease renew the lease of the backend for more ttl time.
+func (b *backend) lease(ttl time.Duration) {
+	b.ttl = ttl
+	if ttl <= 0 {
+		b.expire = time.Time{}
+		return
+	}
+	b.expire = time.Now().Add(ttl)
+}
+
+func (b *backend) expired(now time.Time) bool {
+	return !b.expire.IsZero() && now.After(b.expire)
+}
+
+// drained returns true if the backend is draining and the requests in flight
+// are done or the drain deadline is over.
+func (b *backend) drained(now time.Time) bool {
+	if b.draining.IsZero() {
+		return false
+	}
+	return atomic.LoadInt32(&b.inflight) <= 0 || now.After(b.draining)
+}
+
+// routeEntry holds the backends of one proxied route. httprouter can't remove
+// a handler, so when the last backend goes away the route is only disabled.
+type routeEntry struct {
+	dsts   []*backend
+	active bool
+	// handler is the proxy of the route.
+	handler http.HandlerFunc
+	// cbs are the circuit breakers of the route servers.
+	cbs *Cbs
+	// lb is the LoadBalance of the route.
+	lb LoadBalance
+	// opts is the route with the proxy options used to create it.
+	opts *Route
+	// mirror and shadow are the mirror of the route and the LoadBalance of
+	// the shadow servers. Nil if the route isn't mirrored.
+	mirror *mirror
+	shadow LoadBalance
+	// tags are the LoadBalance of the servers of each tag, used by the rules
+	// and the split policy. The untagged servers are in lb too.
+	tags  map[string]LoadBalance
+	rules []*rule
+	split *SplitPolicy
+}
+
+// shadowed returns true if the servers with the tag are shadow servers.
+func (re *routeEntry) shadowed(tag string) bool {
+	return re.shadow != nil && tag == re.opts.Mirror.Tag
+}
+
+// remove removes the server b from the LoadBalance of the route.
+func (re *routeEntry) remove(key routeKey, b *backend) {
+	if re.shadowed(b.tag) {
+		re.shadow.Remove(key.method, key.path, b.addr)
+		return
+	}
+	re.lb.Remove(key.method, key.path, b.addr)
+	if lb, found := re.tags[b.tag]; found {
+		lb.Remove(key.method, key.path, b.addr)
+	}
+}
+
+// add a new backend or renew the lease and update the weight and tag if it
+// exists. Adding a draining backend again stops the drain.
+func (re *routeEntry) add(key routeKey, dst, tag string, ttl time.Duration, weight int) *backend {
+	b := re.get(dst)
+	if b == nil {
+		b = &backend{addr: dst, tag: tag}
+		re.dsts = append(re.dsts, b)
+	}
+	if b.tag != tag {
+		re.remove(key, b)
+		b.tag = tag
+	}
+	b.lease(ttl)
+	b.draining = time.Time{}
+	if weight <= 0 {
+		weight = 1
+	}
+	b.weight = weight
+	return b
+}
+
+func (re *routeEntry) get(dst string) *backend {
+	for _, b := range re.dsts {
+		if b.addr == dst {
+			return b
+		}
+	}
+	return nil
+}
+
+func (re *routeEntry) del(dst string) bool {
+	for i, b := range re.dsts {
+		if b.addr == dst {
+			re.dsts = append(re.dsts[:i], re.dsts[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,264 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fcavani/droute/errhandler"
+	"github.com/fcavani/droute/responsewriter"
+	"github.com/fcavani/e"
+	log "github.com/fcavani/slog"
+)
+
+// UpgradeIdleTimeout is the time that a upgraded connection, like a
+// websocket, can stay without traffic in both directions before the proxy
+// closes it. Zero disables the timeout.
+var UpgradeIdleTimeout = 10 * time.Minute
+
+// isUpgrade returns true if the request asks for a protocol upgrade.
+func isUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && r.Header.Get("Upgrade") != ""
+}
+
+// headerContains returns true if the comma separated list in the header key
+// has the token.
+func headerContains(h http.Header, key, token string) bool {
+	for _, val := range h[http.CanonicalHeaderKey(key)] {
+		for _, t := range strings.Split(val, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// dial connects to the server in dst.
+func dial(dst *url.URL, timeout time.Duration) (net.Conn, error) {
+	host := dst.Host
+	secure := dst.Scheme == "https" || dst.Scheme == "wss"
+	if dst.Port() == "" {
+		if secure {
+			host = net.JoinHostPort(dst.Hostname(), "443")
+		} else {
+			host = net.JoinHostPort(dst.Hostname(), "80")
+		}
+	}
+	dialer := &net.Dialer{Timeout: timeout}
+	if !secure {
+		conn, err := dialer.Dial("tcp", host)
+		if err != nil {
+			return nil, e.Forward(err)
+		}
+		return conn, nil
+	}
+	config := &tls.Config{}
+	if t, ok := HTTPClient.Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+		config = t.TLSClientConfig.Clone()
+	}
+	if config.ServerName == "" {
+		config.ServerName = dst.Hostname()
+	}
+	conn, err := tls.DialWithDialer(dialer, "tcp", host, config)
+	if err != nil {
+		return nil, e.Forward(err)
+	}
+	return conn, nil
+}
+
+// proxyUpgrade forwards the upgrade request to the server and, if the server
+// switches the protocol, splices the client and the server connections until
+// one of them closes.
+func proxyUpgrade(w *responsewriter.ResponseWriter, r *http.Request, dst *url.URL, oldurl string, timeout time.Duration) {
+	conn, err := dial(dst, timeout)
+	if err != nil {
+		err = e.Push(err, e.New("can't forward the request."))
+		log.Tag("router", "server", "proxy").Error(e.Trace(err))
+		errhandler.ErrHandler(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	err = r.Write(conn)
+	if err != nil {
+		conn.Close()
+		err = e.Push(err, e.New("can't forward the request."))
+		log.Tag("router", "server", "proxy").Error(e.Trace(err))
+		errhandler.ErrHandler(w, http.StatusInternalServerError, err)
+		return
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, r)
+	if err != nil {
+		conn.Close()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			errhandler.ErrHandler(w, http.StatusRequestTimeout, e.New("proxy request timeout"))
+			return
+		}
+		err = e.Push(err, e.New("can't read the response."))
+		log.Tag("router", "server", "proxy").Error(e.Trace(err))
+		errhandler.ErrHandler(w, http.StatusInternalServerError, err)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	upgrade := resp.Header.Get("Upgrade")
+	removeHopHeaders(resp.Header)
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.Header.Set("Connection", "Upgrade")
+		resp.Header.Set("Upgrade", upgrade)
+	}
+	headers := w.Header()
+	for k, vals := range resp.Header {
+		for _, val := range vals {
+			headers.Add(k, val)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// The server refused the upgrade, send the response as is.
+		if w.Stream() {
+			w.SetBody(&upgradeBody{ReadCloser: resp.Body, conn: conn})
+			return
+		}
+		defer conn.Close()
+		defer resp.Body.Close()
+		_, err = io.Copy(w, resp.Body)
+		if err != nil {
+			log.Tag("router", "server", "proxy").Error("Can't copy the buffer: ", err)
+			errhandler.ErrHandler(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+	defer conn.Close()
+
+	client, brw, err := w.Hijack()
+	if err != nil {
+		err = e.Push(err, e.New("can't upgrade the connection"))
+		log.Tag("router", "server", "proxy").Error(e.Trace(err))
+		errhandler.ErrHandler(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer client.Close()
+
+	resp.Header = w.Header()
+	resp.Body = nil
+	err = resp.Write(brw)
+	if err == nil {
+		err = brw.Flush()
+	}
+	if err != nil {
+		log.Tag("router", "server", "proxy").Error("Can't write the response: ", err)
+		return
+	}
+
+	log.Tag("router", "server", "proxy").Printf("%v => %v, upgraded to %v, %v (%v)", oldurl, r.URL, resp.Header.Get("Upgrade"), r.Method, resp.StatusCode)
+	splice(client, brw.Reader, conn, br, UpgradeIdleTimeout)
+	log.Tag("router", "server", "proxy").Printf("%v => %v, upgraded connection closed", oldurl, r.URL)
+}
+
+// upgradeBody closes the connection with the server when the body is closed.
+type upgradeBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (ub *upgradeBody) Close() error {
+	err := ub.ReadCloser.Close()
+	er := ub.conn.Close()
+	if err != nil {
+		return e.Forward(err)
+	}
+	if er != nil {
+		return e.Forward(er)
+	}
+	return nil
+}
+
+// splice copies the data in both directions. When one side stops sending, the
+// write side of the other is closed. It returns when both directions are done
+// or when one of them fails. The connection is idle only if there is no
+// traffic in both directions.
+func splice(client net.Conn, clientR io.Reader, server net.Conn, serverR io.Reader, idle time.Duration) {
+	act := &activity{}
+	act.touch()
+	errc := make(chan error, 2)
+	go func() {
+		errc <- pipe(server, client, clientR, idle, act)
+	}()
+	go func() {
+		errc <- pipe(client, server, serverR, idle, act)
+	}()
+	for i := 0; i < 2; i++ {
+		err := <-errc
+		if err != nil {
+			log.Tag("router", "server", "proxy").DebugLevel().Println("upgraded connection:", err)
+			client.Close()
+			server.Close()
+		}
+	}
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// activity is the time of the last transfer in any direction of a spliced
+// connection, in unix nanoseconds. Use atomic.
+type activity struct {
+	last int64
+}
+
+func (a *activity) touch() {
+	atomic.StoreInt64(&a.last, time.Now().UnixNano())
+}
+
+// deadline returns when the connection will be idle for idle time.
+func (a *activity) deadline(idle time.Duration) time.Time {
+	return time.Unix(0, atomic.LoadInt64(&a.last)).Add(idle)
+}
+
+// pipe copies from src to dst until src ends. A read timeout only fails if the
+// other direction is idle too.
+func pipe(dst, src net.Conn, r io.Reader, idle time.Duration, act *activity) error {
+	buf := make([]byte, 32*1024)
+	for {
+		if idle > 0 {
+			src.SetReadDeadline(act.deadline(idle))
+		}
+		n, err := r.Read(buf)
+		if n > 0 {
+			act.touch()
+			if idle > 0 {
+				dst.SetWriteDeadline(time.Now().Add(idle))
+			}
+			_, er := dst.Write(buf[:n])
+			if er != nil {
+				return e.Forward(er)
+			}
+		}
+		if err == io.EOF {
+			if cw, ok := dst.(closeWriter); ok {
+				return cw.CloseWrite()
+			}
+			return e.New("half close not supported")
+		} else if ne, ok := err.(net.Error); ok && ne.Timeout() && idle > 0 && time.Now().Before(act.deadline(idle)) {
+			// The other direction has traffic.
+			continue
+		} else if err != nil {
+			return e.Forward(err)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fcavani/droute/responsewriter"
+)
+
+func echoServer(t *testing.T, done chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isUpgrade(r) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("no upgrade"))
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer close(done)
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil {
+				return
+			}
+			brw.WriteString(line)
+			brw.Flush()
+		}
+	}))
+}
+
+func upgrade(t *testing.T, addr string) (net.Conn, *bufio.Reader) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatal("wrong response code", resp.StatusCode)
+	}
+	if up := resp.Header.Get("Upgrade"); up != "echo" {
+		t.Fatal("wrong upgrade header", up)
+	}
+	return conn, br
+}
+
+func TestUpgrade(t *testing.T) {
+	done := make(chan struct{})
+	server := echoServer(t, done)
+	defer server.Close()
+
+	rd := NewRedirDst(server.URL)
+	front := httptest.NewServer(responsewriter.Handler(Balance(rd, Proxy("", time.Second))))
+	defer front.Close()
+
+	conn, br := upgrade(t, front.Listener.Addr().String())
+	for _, msg := range []string{"hello\n", "world\n"} {
+		_, err := conn.Write([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != msg {
+			t.Fatal("wrong echo", line)
+		}
+	}
+	conn.Close()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("server connection not closed")
+	}
+}
+
+func TestSpliceIdle(t *testing.T) {
+	client, clientPeer := net.Pipe()
+	server, serverPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer serverPeer.Close()
+
+	done := make(chan struct{})
+	go func() {
+		splice(client, client, server, server, 200*time.Millisecond)
+		close(done)
+	}()
+
+	go func() {
+		clientPeer.Write([]byte("oi"))
+	}()
+	buf := make([]byte, 2)
+	_, err := io.ReadFull(serverPeer, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "oi" {
+		t.Fatal("wrong data", string(buf))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle connection not closed")
+	}
+	_, err = clientPeer.Read(buf)
+	if err != io.EOF {
+		t.Fatal("client connection not closed", err)
+	}
+	_, err = serverPeer.Read(buf)
+	if err != io.EOF {
+		t.Fatal("server connection not closed", err)
+	}
+}
+
+func TestUpgradeRefused(t *testing.T) {
+	HTTPClient = http.DefaultClient
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("no upgrade"))
+	}))
+	defer server.Close()
+
+	rd := NewRedirDst(server.URL)
+	front := httptest.NewServer(responsewriter.Handler(Balance(rd, Proxy("", time.Second))))
+	defer front.Close()
+
+	req, err := http.NewRequest("GET", front.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "echo")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatal("wrong response code", resp.StatusCode)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "no upgrade" {
+		t.Fatal("wrong body", string(buf))
+	}
+}
+
+func TestHeaderContains(t *testing.T) {
+	h := http.Header{}
+	h.Add("Connection", "keep-alive, Upgrade")
+	if !headerContains(h, "connection", "upgrade") {
+		t.Fatal("token not found")
+	}
+	if headerContains(h, "connection", "close") {
+		t.Fatal("token found")
+	}
+}
+
+func TestSpliceOneWay(t *testing.T) {
+	client, clientPeer := net.Pipe()
+	server, serverPeer := net.Pipe()
+	defer clientPeer.Close()
+	defer serverPeer.Close()
+
+	done := make(chan struct{})
+	go func() {
+		splice(client, client, server, server, 200*time.Millisecond)
+		close(done)
+	}()
+
+	// The server pushes and the client is silent, the connection isn't idle.
+	go func() {
+		for i := 0; i < 10; i++ {
+			serverPeer.Write([]byte("oi"))
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	buf := make([]byte, 2)
+	for i := 0; i < 10; i++ {
+		_, err := io.ReadFull(clientPeer, buf)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle connection not closed")
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fcavani/e"
+	log "github.com/fcavani/slog"
+)
+
+// EventType is the type of a change in the routes.
+type EventType string
+
+const (
+	// EventAdd is a server added to a route.
+	EventAdd EventType = "add"
+	// EventRemove is a server removed from a route.
+	EventRemove EventType = "remove"
+	// EventHealth is a change in the health of a server of a route.
+	EventHealth EventType = "health"
+	// EventWeight is a change in the weight of a server of a route.
+	EventWeight EventType = "weight"
+	// EventReset means the events since the last revision were lost, get the
+	// routes again. Only sent by the client.
+	EventReset EventType = "reset"
+)
+
+// Event is a change in the server Addr of a route.
+type Event struct {
+	// Revision of the routes after the change, it grows by one each event.
+	// The revisions start from the time the router started, so the ones of a
+	// previous start are compacted.
+	Revision uint64
+	Type     EventType
+	Time     time.Time
+	Router   string
+	Method   string
+	Path     string
+	Addr     string
+	Tag      string `json:",omitempty"`
+	// Weight of the server in the add and weight events.
+	Weight int `json:",omitempty"`
+	// Health of the server in the health events.
+	Health Health `json:",omitempty"`
+}
+
+// RevisionHeader is the header of the watch response with the revision of the
+// routes when the watch started.
+const RevisionHeader = "X-Revision"
+
+// WatchHistory is the number of events kept to resume the watches.
+var WatchHistory = 1024
+
+// WatchBuffer is the number of events buffered for each watch. A watch that
+// falls behind is closed, the client can resume it from its last revision.
+var WatchBuffer = 256
+
+// WatchKeepAlive is the interval between the keep alive messages of the
+// watches.
+var WatchKeepAlive = 30 * time.Second
+
+// watchHub sends the events to the watches.
+type watchHub struct {
+	lck      sync.Mutex
+	revision uint64
+	history  []Event
+	watches  map[chan Event]struct{}
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{
+		// In microseconds to fit in the integers of javascript.
+		revision: uint64(time.Now().UnixNano() / int64(time.Microsecond)),
+		watches:  make(map[chan Event]struct{}),
+	}
+}
+
+// publish sends the event to all watches.
+func (h *watchHub) publish(ev Event) {
+	h.lck.Lock()
+	defer h.lck.Unlock()
+	h.revision++
+	ev.Revision = h.revision
+	ev.Time = time.Now()
+	if len(h.history) >= WatchHistory && len(h.history) > 0 {
+		copy(h.history, h.history[1:])
+		h.history = h.history[:len(h.history)-1]
+	}
+	if WatchHistory > 0 {
+		h.history = append(h.history, ev)
+	}
+	for ch := range h.watches {
+		select {
+		case ch <- ev:
+		default:
+			log.Tag("router", "watch").Errorf("Watch too slow, closed at revision %v.", ev.Revision)
+			delete(h.watches, ch)
+			close(ch)
+		}
+	}
+}
+
+// watch returns the events after the revision since, if resume is true, a
+// channel with the next events and the current revision. A revision ahead of
+// the current one is from another start of the router.
+func (h *watchHub) watch(since uint64, resume bool) ([]Event, chan Event, uint64, error) {
+	h.lck.Lock()
+	defer h.lck.Unlock()
+	var backlog []Event
+	if resume {
+		if since > h.revision {
+			return nil, nil, 0, e.New("revision unknown")
+		}
+		oldest := h.revision - uint64(len(h.history)) + 1
+		if since+1 < oldest {
+			return nil, nil, 0, e.New("revision compacted")
+		}
+		backlog = append(backlog, h.history[len(h.history)-int(h.revision-since):]...)
+	}
+	ch := make(chan Event, WatchBuffer)
+	h.watches[ch] = struct{}{}
+	return backlog, ch, h.revision, nil
+}
+
+func (h *watchHub) unwatch(ch chan Event) {
+	h.lck.Lock()
+	defer h.lck.Unlock()
+	if _, found := h.watches[ch]; found {
+		delete(h.watches, ch)
+		close(ch)
+	}
+}
+
+// publish sends an event of the server b of the route.
+func (r *Router) publish(typ EventType, key routeKey, b *backend, health Health) {
+	ev := Event{
+		Type:   typ,
+		Router: key.router,
+		Method: key.method,
+		Path:   key.path,
+		Addr:   b.addr,
+		Tag:    b.tag,
+		Health: health,
+	}
+	if typ == EventAdd || typ == EventWeight {
+		ev.Weight = b.weight
+	}
+	r.watch.publish(ev)
+}
+
+// publishHealth sends the health events of the server addr in all routes.
+// tableLck must be locked.
+func (r *Router) publishHealth(addr string, health Health) {
+	for key, entry := range r.table {
+		if !entry.active {
+			continue
+		}
+		if b := entry.get(addr); b != nil {
+			r.publish(EventHealth, key, b, health)
+		}
+	}
+}
+
+// watchSince returns the revision in the query parameter since or in the
+// header Last-Event-ID. resume is false if there is none.
+func watchSince(req *http.Request) (since uint64, resume bool, err error) {
+	s := req.URL.Query().Get("since")
+	if s == "" {
+		s = req.Header.Get("Last-Event-ID")
+	}
+	if s == "" {
+		return 0, false, nil
+	}
+	since, err = strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, false, e.Push(err, "invalid revision")
+	}
+	return since, true, nil
+}
+
+// apiWatch streams the events of the routes as server-sent events, if the
+// client accepts text/event-stream, or as newline delimited json. The query
+// parameter router filters the events of one router and since resumes the
+// watch after that revision. Without since the watch starts in the revision
+// of the header RevisionHeader. If the events after since are lost, like
+// after a restart of the router, the watch answers 410 Gone.
+func (r *Router) apiWatch(w http.ResponseWriter, req *http.Request, _ string) {
+	since, resume, err := watchSince(req)
+	if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	routerName := req.URL.Query().Get("router")
+	id := identity(req)
+	if routerName != "" && !id.allowedRouter(routerName) {
+		problem(w, http.StatusForbidden, e.New("forbidden"))
+		return
+	}
+	backlog, ch, revision, err := r.watch.watch(since, resume)
+	if e.Contains(err, "revision compacted") || e.Contains(err, "revision unknown") {
+		problem(w, http.StatusGone, err)
+		return
+	} else if err != nil {
+		problem(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.watch.unwatch(ch)
+
+	sse := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(RevisionHeader, strconv.FormatUint(revision, 10))
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+
+	send := func(ev Event) error {
+		if routerName != "" && ev.Router != routerName {
+			return nil
+		}
+		if !id.allowed(ev.Router, ev.Path) {
+			return nil
+		}
+		buf, err := json.Marshal(&ev)
+		if err != nil {
+			return e.Forward(err)
+		}
+		if sse {
+			_, err = fmt.Fprintf(w, "id: %v\nevent: %v\ndata: %s\n\n", ev.Revision, ev.Type, buf)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", buf)
+		}
+		return err
+	}
+	keepAlive := func() error {
+		var err error
+		if sse {
+			_, err = fmt.Fprint(w, ": keep alive\n\n")
+		} else {
+			_, err = fmt.Fprint(w, "\n")
+		}
+		return err
+	}
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	for _, ev := range backlog {
+		err = send(ev)
+		if err != nil {
+			return
+		}
+	}
+	flush()
+
+	ticker := time.NewTicker(WatchKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			err = send(ev)
+		case <-ticker.C:
+			err = keepAlive()
+		case <-req.Context().Done():
+			return
+		case <-r.stop:
+			return
+		}
+		if err != nil {
+			log.Tag("router", "watch").DebugLevel().Printf("Watch of %v closed: %v", req.RemoteAddr, err)
+			return
+		}
+		flush()
+	}
+}
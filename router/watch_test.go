@@ -0,0 +1,268 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fcavani/e"
+)
+
+func TestWatchHub(t *testing.T) {
+	defer func(history, buffer int) {
+		WatchHistory, WatchBuffer = history, buffer
+	}(WatchHistory, WatchBuffer)
+	WatchHistory, WatchBuffer = 3, 1
+
+	h := newWatchHub()
+	base := h.revision
+	if base == 0 {
+		t.Fatal("revision doesn't start at the start time")
+	}
+	_, ch, _, err := h.watch(0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		h.publish(Event{Type: EventAdd})
+	}
+	// Too slow, closed after the first event.
+	if ev := <-ch; ev.Revision != base+1 {
+		t.Fatal("wrong revision", ev.Revision)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatal("watch not closed")
+	}
+
+	backlog, ch, rev, err := h.watch(base+2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.unwatch(ch)
+	if rev != base+5 {
+		t.Fatal("wrong revision", rev)
+	}
+	if len(backlog) != 3 || backlog[0].Revision != base+3 || backlog[2].Revision != base+5 {
+		t.Fatal("wrong backlog", backlog)
+	}
+	backlog, ch2, _, err := h.watch(base+5, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.unwatch(ch2)
+	if len(backlog) != 0 {
+		t.Fatal("wrong backlog", backlog)
+	}
+	_, _, _, err = h.watch(base+1, true)
+	if err == nil || !e.Contains(err, "revision compacted") {
+		t.Fatal("wrong error", err)
+	}
+	_, _, _, err = h.watch(base+6, true)
+	if err == nil || !e.Contains(err, "revision unknown") {
+		t.Fatal("wrong error", err)
+	}
+
+	// A revision of a previous start is compacted.
+	_, _, _, err = newWatchHub().watch(base+5, true)
+	if err == nil || !e.Contains(err, "revision compacted") {
+		t.Fatal("wrong error", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	r := &Router{}
+	err := r.Start(NewRouters(), NewWeightedRoundRobin(), time.Minute, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+	// The watches aren't behind the handlers, the rest of the API is.
+	var behind int32
+	r.HTTPHandlers(func(first http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if strings.HasSuffix(req.URL.Path, "/watch") {
+				t.Error("watch behind the handlers", req.URL)
+			}
+			atomic.AddInt32(&behind, 1)
+			first.ServeHTTP(w, req)
+		})
+	})
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	watch := func(query, accept string) (*http.Response, *bufio.Reader) {
+		req, err := http.NewRequest("GET", server.URL+APIPrefix+"/watch"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatal("wrong status code", resp.StatusCode)
+		}
+		return resp, bufio.NewReader(resp.Body)
+	}
+	next := func(rd *bufio.Reader) Event {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ev Event
+		err = json.Unmarshal([]byte(line), &ev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+
+	resp, rd := watch("?router="+DefaultRouter, "")
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Fatal("wrong content type", ct)
+	}
+	base, err := strconv.ParseUint(resp.Header.Get(RevisionHeader), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Register(&Route{Methode: "GET", Router: DefaultRouter, Path: "/watch", RedirTo: "http://10.0.0.1", Tag: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only renew, no event.
+	err = r.Register(&Route{Methode: "GET", Router: DefaultRouter, Path: "/watch", RedirTo: "http://10.0.0.1", Tag: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.SetWeight(DefaultRouter, "GET", "/watch", "http://10.0.0.1", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.tableLck.Lock()
+	r.publishHealth("http://10.0.0.1", HealthDown)
+	r.tableLck.Unlock()
+	err = r.Del(DefaultRouter, "GET", "/watch", "http://10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		typ    EventType
+		weight int
+		health Health
+	}{
+		{EventAdd, 1, HealthUnknown},
+		{EventWeight, 5, ""},
+		{EventHealth, 0, HealthDown},
+		{EventRemove, 0, ""},
+	}
+	for i, test := range tests {
+		ev := next(rd)
+		if ev.Revision != base+uint64(i+1) || ev.Type != test.typ || ev.Weight != test.weight || ev.Health != test.health {
+			t.Fatal(i, "wrong event", ev)
+		}
+		if ev.Router != DefaultRouter || ev.Method != "GET" || ev.Path != "/watch" || ev.Addr != "http://10.0.0.1" || ev.Tag != "v1" {
+			t.Fatal(i, "wrong event", ev)
+		}
+	}
+
+	// Resume as server-sent events.
+	req, err := http.NewRequest("GET", server.URL+APIPrefix+"/watch", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(base+2, 10))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatal("wrong content type", ct)
+	}
+	rd = bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 4 {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	if lines[0] != "id: "+strconv.FormatUint(base+3, 10) || lines[1] != "event: health" || !strings.HasPrefix(lines[2], "data: {") || lines[3] != "" {
+		t.Fatal("wrong event", lines)
+	}
+
+	// Other router.
+	resp, rd = watch("?router=other", "")
+	defer resp.Body.Close()
+	err = r.Register(&Route{Methode: "GET", Router: DefaultRouter, Path: "/watch", RedirTo: "http://10.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		line, _ := rd.ReadString('\n')
+		if line != "" {
+			t.Error("event of other router", line)
+		}
+	}()
+	time.Sleep(100 * time.Millisecond)
+	resp.Body.Close()
+	<-done
+
+	req, err = http.NewRequest("GET", server.URL+APIPrefix+"/watch?since=a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatal("wrong status code", resp.StatusCode)
+	}
+	// A revision ahead is from another start of the router.
+	req, err = http.NewRequest("GET", server.URL+APIPrefix+"/watch?since="+strconv.FormatUint(base+1000, 10), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Fatal("wrong status code", resp.StatusCode)
+	}
+
+	resp, err = http.Get(server.URL + APIPrefix + "/routers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("wrong status code", resp.StatusCode)
+	}
+	if n := atomic.LoadInt32(&behind); n != 1 {
+		t.Fatal("api not behind the handlers", n)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"github.com/fcavani/e"
+	log "github.com/fcavani/slog"
+)
+
+// WeightedRoundRobin is a smooth weighted round robin, like the one in nginx.
+// The addresses with more weight receive more requests and the requests are
+// interleaved between the addresses. Addresses without weight have weight 1.
+type WeightedRoundRobin struct {
+	*RoundRobin
+}
+
+// NewWeightedRoundRobin creates a new weighted round robin balancer.
+func NewWeightedRoundRobin() *WeightedRoundRobin {
+	return &WeightedRoundRobin{
+		RoundRobin: NewRoundRobin(),
+	}
+}
+
+// Clone returns a new empty weighted round robin.
+func (wrr *WeightedRoundRobin) Clone() LoadBalance {
+	return NewWeightedRoundRobin()
+}
+
+// SetWeight sets the weight of ip. If ip already has a weight it is updated in
+// place. The weight is kept if ip is removed and added again.
+func (wrr *WeightedRoundRobin) SetWeight(method, path, ip string, weight int) {
+	if path == "" {
+		path = "/"
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	wrr.lck.Lock()
+	defer wrr.lck.Unlock()
+	m, ok := wrr.ips[method]
+	if !ok {
+		return
+	}
+	p, ok := m[path]
+	if !ok {
+		return
+	}
+	if p.weight == nil {
+		p.weight = make(map[string]int)
+	}
+	p.weight[ip] = weight
+}
+
+// Next returns the address with the greatest current weight.
+func (wrr *WeightedRoundRobin) Next(method, path string) string {
+	if path == "" {
+		path = "/"
+	}
+	wrr.lck.Lock()
+	defer wrr.lck.Unlock()
+	m, ok := wrr.ips[method]
+	if !ok {
+		return ""
+	}
+	p := findPath(m, path)
+	if p == nil || len(p.ips) == 0 {
+		return ""
+	}
+	if p.current == nil {
+		p.current = make(map[string]int)
+	}
+	total := 0
+	best := ""
+	for _, ip := range p.ips {
+		w, found := p.weight[ip]
+		if !found {
+			w = 1
+		}
+		p.current[ip] += w
+		total += w
+		if best == "" || p.current[ip] > p.current[best] {
+			best = ip
+		}
+	}
+	p.current[best] -= total
+	return best
+}
+
+// Remove exclude a ip from the list of proxies.
+func (wrr *WeightedRoundRobin) Remove(method, path, target string) {
+	wrr.RoundRobin.Remove(method, path, target)
+	if path == "" {
+		path = "/"
+	}
+	wrr.lck.Lock()
+	defer wrr.lck.Unlock()
+	m, ok := wrr.ips[method]
+	if !ok {
+		return
+	}
+	p := findPath(m, path)
+	if p == nil {
+		return
+	}
+	delete(p.current, target)
+}
+
+// SetWeight changes the weight of the server dst in the route. Zero is the same
+// as one.
+func (r *Router) SetWeight(routerName, method, path, dst string, weight int) (err error) {
+	defer func() {
+		if err != nil {
+			log.Errorf("Can't set the weight (%v, %v, %v => %v) error: %v", routerName, method, path, dst, err)
+		}
+	}()
+	path, err = checkRoute(routerName, method, path, dst)
+	if err != nil {
+		return
+	}
+
+	key := routeKey{router: routerName, method: method, path: path}
+
+	r.tableLck.Lock()
+	defer r.tableLck.Unlock()
+
+	entry, found := r.table[key]
+	if !found || !entry.active {
+		err = e.New("route not found")
+		return
+	}
+	b := entry.get(dst)
+	if b == nil {
+		err = e.New("destiny not found")
+		return
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	b.weight = weight
+	lbs := []LoadBalance{entry.shadow}
+	if !entry.shadowed(b.tag) {
+		lbs = []LoadBalance{entry.lb, entry.tags[b.tag]}
+	}
+	for _, lb := range lbs {
+		if w, ok := lb.(Weighter); ok {
+			w.SetWeight(key.method, key.path, b.addr, weight)
+		}
+	}
+	r.publish(EventWeight, key, b, "")
+	r.save(key, entry)
+	return
+}
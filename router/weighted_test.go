@@ -0,0 +1,118 @@
+// Copyright 2017 Felipe A. Cavani. All rights reserved.
+// Use of this source code is governed by the Apache License 2.0
+// license that can be found in the LICENSE file.
+
+package router
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeightedRoundRobin(t *testing.T) {
+	wrr := NewWeightedRoundRobin()
+	if dst := wrr.Next("GET", "/"); dst != "" {
+		t.Fatal("next fail", dst)
+	}
+	wrr.AddAddrs("GET", "/", "a")
+	wrr.AddAddrs("GET", "/", "b")
+	wrr.AddAddrs("GET", "/", "c")
+	wrr.SetWeight("GET", "/", "a", 5)
+	wrr.SetWeight("GET", "/", "b", 1)
+	wrr.SetWeight("GET", "/", "c", 1)
+
+	// The nginx sequence for the weights 5, 1, 1.
+	seq := make([]string, 0, 7)
+	for i := 0; i < 7; i++ {
+		seq = append(seq, wrr.Next("GET", "/"))
+	}
+	if s := strings.Join(seq, ""); s != "aabacaa" {
+		t.Fatal("wrong sequence", s)
+	}
+
+	// Update in place.
+	wrr.SetWeight("GET", "/", "a", 1)
+	count := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		count[wrr.Next("GET", "/")]++
+	}
+	if count["a"] != 10 || count["b"] != 10 || count["c"] != 10 {
+		t.Fatal("wrong distribution", count)
+	}
+
+	wrr.Remove("GET", "/", "a")
+	wrr.Remove("GET", "/", "b")
+	for i := 0; i < 3; i++ {
+		if dst := wrr.Next("GET", "/"); dst != "c" {
+			t.Fatal("wrong address", dst)
+		}
+	}
+}
+
+func TestRouterWeight(t *testing.T) {
+	r := &Router{}
+	err := r.Start(NewRouters(), NewWeightedRoundRobin(), 60*time.Second, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Stop()
+
+	err = r.Register(&Route{
+		Methode: "GET",
+		Router:  DefaultRouter,
+		Path:    "/w",
+		RedirTo: "a",
+		Weight:  3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Add(DefaultRouter, "GET", "/w", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lb := routeLB(r, DefaultRouter, "GET", "/w")
+
+	count := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		count[lb.Next("GET", "/w")]++
+	}
+	if count["a"] != 6 || count["b"] != 2 {
+		t.Fatal("wrong distribution", count)
+	}
+
+	// Register again changes the weight.
+	err = r.Register(&Route{
+		Methode: "GET",
+		Router:  DefaultRouter,
+		Path:    "/w",
+		RedirTo: "a",
+		Weight:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count = make(map[string]int)
+	for i := 0; i < 8; i++ {
+		count[lb.Next("GET", "/w")]++
+	}
+	if count["a"] != 4 || count["b"] != 4 {
+		t.Fatal("wrong distribution", count)
+	}
+
+	rs, err := r.Get(DefaultRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, route := range rs {
+		if route.Path != "/w" {
+			continue
+		}
+		for _, b := range route.Backends {
+			if b.Weight != 1 {
+				t.Fatal("wrong weight", b.Addr, b.Weight)
+			}
+		}
+	}
+}